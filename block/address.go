@@ -0,0 +1,177 @@
+package block
+
+import (
+	"blockchain-study/utils"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const (
+	// 1回のAddressTransactionsで返す件数の既定値と上限
+	ADDRESS_TRANSACTIONS_DEFAULT_LIMIT = 20
+	ADDRESS_TRANSACTIONS_MAX_LIMIT     = 100
+)
+
+// あるアドレスが送金または受け取りをしたTransactionの記録
+// Amountはこのアドレスの残高の増減、BalanceはこのTransactionを反映した後の残高
+type AddressTransaction struct {
+	Transaction *Transaction
+	BlockHeight int
+	Timestamp   int64
+	Amount      utils.Amount
+	Balance     utils.Amount
+}
+
+// アドレスごとに関係するTransactionを古い順に持つインデックス
+// BalanceIndexと同じくBlockを繋げるたびに追加し、チェーンが置き換わった時は分岐点まで巻き戻す
+type AddressIndex struct {
+	mux     sync.Mutex
+	entries map[string][]*AddressTransaction
+	// Blockの高さごとに、そのBlockで記録を追加したアドレス
+	heights [][]string
+}
+
+func NewAddressIndex() *AddressIndex {
+	return &AddressIndex{entries: make(map[string][]*AddressTransaction)}
+}
+
+// 次の高さのBlockに含まれるTransactionを記録する。deltasはTransactionごとのアドレスの残高の増減
+func (ai *AddressIndex) push(b *Block, deltas []map[string]utils.Amount) {
+	ai.mux.Lock()
+	defer ai.mux.Unlock()
+
+	height := len(ai.heights)
+	var addresses []string
+	for i, delta := range deltas {
+		for blockchainAddress, amount := range delta {
+			entries := ai.entries[blockchainAddress]
+			var balance utils.Amount = 0
+			if len(entries) > 0 {
+				balance = entries[len(entries)-1].Balance
+			}
+			if len(entries) == 0 || entries[len(entries)-1].BlockHeight != height {
+				addresses = append(addresses, blockchainAddress)
+			}
+			ai.entries[blockchainAddress] = append(entries, &AddressTransaction{
+				Transaction: b.transactions[i],
+				BlockHeight: height,
+				Timestamp:   b.header.timestamp,
+				Amount:      amount,
+				Balance:     balance + amount,
+			})
+		}
+	}
+	ai.heights = append(ai.heights, addresses)
+}
+
+// height以降のBlockの記録を取り除く
+func (ai *AddressIndex) rollback(height int) {
+	ai.mux.Lock()
+	defer ai.mux.Unlock()
+	for len(ai.heights) > height {
+		last := len(ai.heights) - 1
+		for _, blockchainAddress := range ai.heights[last] {
+			entries := ai.entries[blockchainAddress]
+			n := len(entries)
+			for n > 0 && entries[n-1].BlockHeight == last {
+				n -= 1
+			}
+			if n == 0 {
+				delete(ai.entries, blockchainAddress)
+			} else {
+				ai.entries[blockchainAddress] = entries[:n]
+			}
+		}
+		ai.heights = ai.heights[:last]
+	}
+}
+
+// 引数の人の記録を新しい順にlimit件まで返す
+// cursorは前回の結果のNextCursorで、0の場合は最新から返す。続きがなければNextCursorは0
+func (ai *AddressIndex) Transactions(blockchainAddress string, cursor int, limit int) ([]*AddressTransaction, int) {
+	ai.mux.Lock()
+	defer ai.mux.Unlock()
+
+	entries := ai.entries[blockchainAddress]
+	end := len(entries)
+	if cursor > 0 && cursor < end {
+		end = cursor
+	}
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+
+	transactions := make([]*AddressTransaction, 0, end-start)
+	for i := end - 1; i >= start; i-- {
+		transactions = append(transactions, entries[i])
+	}
+	return transactions, start
+}
+
+// AddressTransactionsの結果。Confirmationsは取得した時点のチェーンの長さから求める
+type AddressHistory struct {
+	BlockchainAddress string
+	Transactions      []*AddressTransaction
+	ChainLength       int
+	NextCursor        int
+}
+
+func (ah *AddressHistory) MarshalJSON() ([]byte, error) {
+	type entry struct {
+		Transaction   *Transaction `json:"transaction"`
+		BlockHeight   int          `json:"block_height"`
+		Timestamp     int64        `json:"timestamp"`
+		Confirmations int          `json:"confirmations"`
+		Amount        utils.Amount `json:"amount"`
+		Balance       utils.Amount `json:"balance"`
+	}
+	entries := make([]*entry, 0, len(ah.Transactions))
+	for _, at := range ah.Transactions {
+		entries = append(entries, &entry{
+			Transaction:   at.Transaction,
+			BlockHeight:   at.BlockHeight,
+			Timestamp:     at.Timestamp,
+			Confirmations: ah.ChainLength - at.BlockHeight,
+			Amount:        at.Amount,
+			Balance:       at.Balance,
+		})
+	}
+
+	// 続きがない場合はnext_cursorを返さない
+	var nextCursor string
+	if ah.NextCursor > 0 {
+		nextCursor = fmt.Sprintf("%d", ah.NextCursor)
+	}
+	return json.Marshal(struct {
+		BlockchainAddress string   `json:"blockchain_address"`
+		Transactions      []*entry `json:"transactions"`
+		NextCursor        string   `json:"next_cursor,omitempty"`
+	}{
+		BlockchainAddress: ah.BlockchainAddress,
+		Transactions:      entries,
+		NextCursor:        nextCursor,
+	})
+}
+
+// 引数の人が送金または受け取りをした承認済みのTransactionを新しい順に返す
+// limitが0以下の場合はADDRESS_TRANSACTIONS_DEFAULT_LIMIT件、上限はADDRESS_TRANSACTIONS_MAX_LIMIT件
+func (bc *Blockchain) AddressTransactions(blockchainAddress string, cursor int, limit int) *AddressHistory {
+	if limit <= 0 {
+		limit = ADDRESS_TRANSACTIONS_DEFAULT_LIMIT
+	}
+	if limit > ADDRESS_TRANSACTIONS_MAX_LIMIT {
+		limit = ADDRESS_TRANSACTIONS_MAX_LIMIT
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	transactions, nextCursor := bc.addresses.Transactions(blockchainAddress, cursor, limit)
+	return &AddressHistory{
+		BlockchainAddress: blockchainAddress,
+		Transactions:      transactions,
+		ChainLength:       len(bc.chain),
+		NextCursor:        nextCursor,
+	}
+}
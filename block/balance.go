@@ -0,0 +1,111 @@
+package block
+
+import (
+	"blockchain-study/utils"
+	"sync"
+)
+
+// アドレスごとの承認済みの残高
+// Blockごとの増減を高さの順に持っておき、チェーンが置き換わった時は分岐点まで巻き戻す
+type BalanceIndex struct {
+	mux      sync.Mutex
+	balances map[string]utils.Amount
+	deltas   []map[string]utils.Amount
+}
+
+func NewBalanceIndex() *BalanceIndex {
+	return &BalanceIndex{balances: make(map[string]utils.Amount)}
+}
+
+func (bi *BalanceIndex) Balance(blockchainAddress string) utils.Amount {
+	bi.mux.Lock()
+	defer bi.mux.Unlock()
+	return bi.balances[blockchainAddress]
+}
+
+// 反映済みのBlockの数
+func (bi *BalanceIndex) Height() int {
+	bi.mux.Lock()
+	defer bi.mux.Unlock()
+	return len(bi.deltas)
+}
+
+// 次の高さのBlockによる増減を反映する
+func (bi *BalanceIndex) push(delta map[string]utils.Amount) {
+	bi.mux.Lock()
+	defer bi.mux.Unlock()
+	bi.add(delta, 1)
+	bi.deltas = append(bi.deltas, delta)
+}
+
+// height以降のBlockによる増減を取り消し、heightより前のBlockまでの残高に戻す
+func (bi *BalanceIndex) rollback(height int) {
+	bi.mux.Lock()
+	defer bi.mux.Unlock()
+	for len(bi.deltas) > height {
+		last := len(bi.deltas) - 1
+		bi.add(bi.deltas[last], -1)
+		bi.deltas = bi.deltas[:last]
+	}
+}
+
+// bi.muxをロックした状態で呼ぶこと
+func (bi *BalanceIndex) add(delta map[string]utils.Amount, sign utils.Amount) {
+	for blockchainAddress, amount := range delta {
+		balance := bi.balances[blockchainAddress] + sign*amount
+		if balance == 0 {
+			delete(bi.balances, blockchainAddress)
+		} else {
+			bi.balances[blockchainAddress] = balance
+		}
+	}
+}
+
+// transactionsによるアドレスごとの残高の増減
+// lookupはtransactionsより前までで未使用の出力を返す。LEDGER_ACCOUNTでは呼ばれない
+func balanceDelta(transactions []*Transaction, lookup func(OutPoint) *TxOutput) map[string]utils.Amount {
+	return sumDeltas(transactionDeltas(transactions, lookup))
+}
+
+func sumDeltas(deltas []map[string]utils.Amount) map[string]utils.Amount {
+	delta := make(map[string]utils.Amount)
+	for _, d := range deltas {
+		for blockchainAddress, amount := range d {
+			delta[blockchainAddress] += amount
+		}
+	}
+	return delta
+}
+
+// transactionsそれぞれによるアドレスごとの残高の増減
+// 送金した人と受け取った人は増減が0でも含める
+func transactionDeltas(transactions []*Transaction, lookup func(OutPoint) *TxOutput) []map[string]utils.Amount {
+	deltas := make([]map[string]utils.Amount, 0, len(transactions))
+	// 同じBlockの中で作られた出力を使う場合に備えて、作った出力も覚えておく
+	created := make(map[OutPoint]*TxOutput)
+	for _, t := range transactions {
+		delta := make(map[string]utils.Amount)
+		if t.IsUTXO() {
+			for _, in := range t.inputs {
+				out, ok := created[in.previousOutput]
+				if !ok {
+					out = lookup(in.previousOutput)
+				}
+				if out != nil {
+					delta[out.blockchainAddress] -= out.value
+				}
+			}
+			for _, out := range t.outputs {
+				delta[out.blockchainAddress] += out.value
+			}
+		} else {
+			if t.senderBlockchainAddress != MINING_SENDER {
+				delta[t.senderBlockchainAddress] -= t.value + t.fee
+			}
+			delta[t.recipientBlockchainAddress] += t.value
+		}
+		applyOutputs(created, t)
+		deltas = append(deltas, delta)
+	}
+	return deltas
+}
@@ -2,28 +2,134 @@ package block
 
 import (
 	"blockchain-study/utils"
+	"blockchain-study/wallet"
+	"context"
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
+	"net/http"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	// マイニングで設定した一致する行頭文字数
-	MINING_DIFFICULTY = 3
+	// Proof of Workの途中で何nonceごとにキャンセルを確認するか
+	POW_CANCEL_CHECK_INTERVAL = 1024
+
+	// genesisと最初の調整までに使う難易度。ハッシュの行頭が16進数で3文字0になるのと同じ程度
+	MINING_DIFFICULTY = 4096
 	MINING_SENDER     = "THE BLOCKCHAIN"
-	MINING_REWARD     = 1.0
+	MINING_REWARD     = 1 * utils.COIN
 	MINING_TIMER_SEC  = 20
+
+	// 1つのBlockに入れられるTransactionのJsonの合計サイズ(byte)
+	MAX_BLOCK_SIZE = 1000000
+
+	// genesisブロックのtimestamp。全てのノードが同じgenesisから始まるよう固定する
+	GENESIS_TIMESTAMP = 1704067200000000000
 )
 
-type Block struct {
+// Blockのヘッダー。Proof of WorkとBlockのハッシュはヘッダーだけを対象にする
+// Transactionはマークルルートでヘッダーに結び付けられる
+type BlockHeader struct {
 	timestamp    int64
 	nonce        int
 	previousHash [32]byte
+	merkleRoot   [32]byte
+	difficulty   uint64
+}
+
+func (h *BlockHeader) Timestamp() int64 {
+	return h.timestamp
+}
+
+func (h *BlockHeader) Nonce() int {
+	return h.nonce
+}
+
+func (h *BlockHeader) PreviousHash() [32]byte {
+	return h.previousHash
+}
+
+func (h *BlockHeader) MerkleRoot() [32]byte {
+	return h.merkleRoot
+}
+
+func (h *BlockHeader) Difficulty() uint64 {
+	return h.difficulty
+}
+
+// ヘッダーをハッシュ化したものを返す。これがBlockのハッシュになる
+func (h *BlockHeader) Hash() [32]byte {
+	m, _ := json.Marshal(h)
+	return sha256.Sum256([]byte(m))
+}
+
+func (h *BlockHeader) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Timestamp    int64  `json:"timestamp"`
+		Nonce        int    `json:"nonce"`
+		PreviousHash string `json:"previous_hash"`
+		MerkleRoot   string `json:"merkle_root"`
+		Difficulty   uint64 `json:"difficulty"`
+	}{
+		Timestamp:    h.timestamp,
+		Nonce:        h.nonce,
+		PreviousHash: fmt.Sprintf("%x", h.previousHash),
+		MerkleRoot:   fmt.Sprintf("%x", h.merkleRoot),
+		Difficulty:   h.difficulty,
+	})
+}
+
+// MarshalJSONで書き出したJsonからBlockHeaderを復元する
+func (h *BlockHeader) UnmarshalJSON(data []byte) error {
+	v := struct {
+		Timestamp    int64  `json:"timestamp"`
+		Nonce        int    `json:"nonce"`
+		PreviousHash string `json:"previous_hash"`
+		MerkleRoot   string `json:"merkle_root"`
+		Difficulty   uint64 `json:"difficulty"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := decodeHash(v.PreviousHash, &h.previousHash); err != nil {
+		return fmt.Errorf("previous_hash: %v", err)
+	}
+	if err := decodeHash(v.MerkleRoot, &h.merkleRoot); err != nil {
+		return fmt.Errorf("merkle_root: %v", err)
+	}
+	h.timestamp = v.Timestamp
+	h.nonce = v.Nonce
+	h.difficulty = v.Difficulty
+	return nil
+}
+
+// 16進数の文字列を32byteのハッシュに変換する
+func decodeHash(s string, hash *[32]byte) error {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	if len(b) != len(hash) {
+		return fmt.Errorf("invalid hash length: %d", len(b))
+	}
+	copy(hash[:], b)
+	return nil
+}
+
+type Block struct {
+	header       BlockHeader
 	transactions []*Transaction
 }
 
@@ -33,81 +139,357 @@ func NewBlock(nonce int, previousHash [32]byte, transactions []*Transaction) *Bl
 	b := new(Block)
 
 	// timestampのint64を返す
-	b.timestamp = time.Now().UnixNano()
-	b.nonce = nonce
-	b.previousHash = previousHash
+	b.header.timestamp = time.Now().UnixNano()
+	b.header.nonce = nonce
+	b.header.previousHash = previousHash
+	b.header.merkleRoot = TransactionsMerkleRoot(transactions)
+	b.header.difficulty = MINING_DIFFICULTY
 	b.transactions = transactions
 
 	return b
 }
 
+// 全てのノードで共通のgenesisブロック。Transactionを持たず、timestampと難易度は固定
+func NewGenesisBlock() *Block {
+	b := NewBlock(0, (&Block{}).Hash(), []*Transaction{})
+	b.header.timestamp = GENESIS_TIMESTAMP
+	return b
+}
+
+func (b *Block) Header() *BlockHeader {
+	return &b.header
+}
+
+func (b *Block) Transactions() []*Transaction {
+	return b.transactions
+}
+
 func (b *Block) Print() {
-	fmt.Printf("timestamp     %d\n", b.timestamp)
-	fmt.Printf("nonce     %d\n", b.nonce)
-	fmt.Printf("previous_hash     %x\n", b.previousHash)
+	fmt.Printf("timestamp     %d\n", b.header.timestamp)
+	fmt.Printf("nonce     %d\n", b.header.nonce)
+	fmt.Printf("previous_hash     %x\n", b.header.previousHash)
+	fmt.Printf("merkle_root     %x\n", b.header.merkleRoot)
+	fmt.Printf("difficulty     %d\n", b.header.difficulty)
 	for _, t := range b.transactions {
 		t.Print()
 	}
 }
 
-// レシーバーのブロックをハッシュ化したものを返す。
+// レシーバーのブロックのヘッダーをハッシュ化したものを返す。
 func (b *Block) Hash() [32]byte {
-	m, _ := json.Marshal(b)
-	return sha256.Sum256([]byte(m))
+	return b.header.Hash()
+}
+
+// MAX_BLOCK_SIZEと比べるサイズ。含まれるTransactionのサイズの合計
+func (b *Block) Size() int {
+	size := 0
+	for _, t := range b.transactions {
+		size += t.Size()
+	}
+	return size
 }
 
 // ただのjson.Marshalではプライベートなプロパティにアクセスできないため、Marshalを上書き
 func (b *Block) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Timestamp    int64          `json:"timestamp"`
-		Nonce        int            `json:"nonce"`
-		PreviousHash string         `json:"previous_hash"`
+		Header       *BlockHeader   `json:"header"`
 		Transactions []*Transaction `json:"transactions"`
 	}{
-		Timestamp:    b.timestamp,
-		Nonce:        b.nonce,
-		PreviousHash: fmt.Sprintf("%x", b.previousHash),
+		Header:       &b.header,
 		Transactions: b.transactions,
 	})
 }
 
+// MarshalJSONで書き出したJsonからBlockを復元する
+func (b *Block) UnmarshalJSON(data []byte) error {
+	v := struct {
+		Header       *BlockHeader   `json:"header"`
+		Transactions []*Transaction `json:"transactions"`
+	}{
+		Header: &b.header,
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	// nullのTransactionは検証の途中で参照できないので、読み込む時点で拒否する
+	for i, t := range v.Transactions {
+		if t == nil {
+			return fmt.Errorf("%w: transaction %d is null", ErrMissingFields, i)
+		}
+	}
+	b.transactions = v.Transactions
+	return nil
+}
+
 type Blockchain struct {
-	transactionPool   []*Transaction
+	transactionPool   *Mempool
 	chain             []*Block
 	blockchainAddress string
 	port              uint16
 	mux               sync.Mutex
+	storage           *Storage
+
+	// 残高の持ち方(LEDGER_ACCOUNTかLEDGER_UTXO)。utxosはLEDGER_UTXOの時だけ使う
+	ledger string
+	utxos  *UTXOSet
+
+	// アドレスごとの承認済みの残高と、関係するTransaction、送金した人ごとの使用済みのnonce
+	// Blockを繋げるたびに更新する
+	balances  *BalanceIndex
+	addresses *AddressIndex
+	nonces    *NonceIndex
+
+	// Proof of Work
+	miningWorkers int
+	cancelMining  context.CancelFunc
+	muxMining     sync.Mutex
+
+	// マイニングの実績。MinerStatusで返す
+	blocksMined int
+	lastMinedAt time.Time
+	hashes      uint64
+	hashingTime time.Duration
+
+	host         string
+	seeds        []string
+	neighbors    []string
+	muxNeighbors sync.Mutex
 }
 
 // 新しいブロックチェーンの作成
 func NewBlockChain(blockchainAddress string, port uint16) *Blockchain {
-	b := &Block{}
 	bc := new(Blockchain)
+	bc.transactionPool = NewMempool(MEMPOOL_MAX_TRANSACTIONS, MEMPOOL_MAX_BYTES, MEMPOOL_TRANSACTION_TTL)
+	bc.balances = NewBalanceIndex()
+	bc.addresses = NewAddressIndex()
+	bc.nonces = NewNonceIndex()
+	bc.ledger = LEDGER_ACCOUNT
 	bc.blockchainAddress = blockchainAddress
-	bc.CreateBlock(0, b.Hash())
+	bc.appendBlock(NewGenesisBlock())
 	bc.port = port
 	return bc
 }
 
+// storageに保存されたチェーンとtransactionPoolを読み込んでブロックチェーンを作成
+// 保存されたものがなければgenesisブロックを作って保存する
+// ledgerはLEDGER_ACCOUNTかLEDGER_UTXOで、保存されたチェーンと同じものを指定すること
+func NewBlockChainWithStorage(blockchainAddress string, port uint16, ledger string, storage *Storage) (*Blockchain, error) {
+	if ledger != LEDGER_ACCOUNT && ledger != LEDGER_UTXO {
+		return nil, fmt.Errorf("unknown ledger: %s", ledger)
+	}
+	bc := new(Blockchain)
+	bc.transactionPool = NewMempool(MEMPOOL_MAX_TRANSACTIONS, MEMPOOL_MAX_BYTES, MEMPOOL_TRANSACTION_TTL)
+	bc.balances = NewBalanceIndex()
+	bc.addresses = NewAddressIndex()
+	bc.nonces = NewNonceIndex()
+	bc.blockchainAddress = blockchainAddress
+	bc.port = port
+	bc.ledger = ledger
+	bc.storage = storage
+	if ledger == LEDGER_UTXO {
+		bc.utxos = NewUTXOSet()
+	}
+
+	chain, err := storage.LoadBlocks()
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		bc.appendBlock(NewGenesisBlock())
+		return bc, nil
+	}
+	if err := bc.validateChain(chain); err != nil {
+		return nil, fmt.Errorf("stored chain: %w", err)
+	}
+	bc.chain = chain
+
+	// 保存されたUTXOの集合が最後のBlockまで反映されていなければ、reindexでチェーンから作り直す
+	if ledger == LEDGER_UTXO {
+		utxos, err := storage.LoadUTXOSet()
+		if err != nil {
+			return nil, err
+		}
+		if utxos != nil {
+			bc.utxos = utxos
+		}
+	}
+	bc.reindex(0)
+
+	// 保存されていた時刻は持たないので、読み込んだ時点からttlを数え直す
+	pool, err := storage.LoadTransactionPool()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range pool {
+		if err := bc.transactionPool.Add(t); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+	}
+	bc.pruneTransactionPool()
+
+	log.Printf("action=load_blockchain, blocks=%d, transactions=%d", len(bc.chain), bc.transactionPool.Len())
+	return bc, nil
+}
+
+// transactionPoolにあるTransactionを受け付けた順に返す。返したsliceは呼び出し側で変更してよい
 func (bc *Blockchain) TransactionPool() []*Transaction {
-	return bc.transactionPool
+	return bc.transactionPool.Transactions()
+}
+
+func (bc *Blockchain) Ledger() string {
+	return bc.ledger
+}
+
+// LEDGER_UTXOの場合のUTXOの集合。LEDGER_ACCOUNTの場合はnil
+func (bc *Blockchain) UTXOs() *UTXOSet {
+	return bc.utxos
 }
 
+// 近隣ノードがResolveConflictsで取得するチェーン。Blockを繋げている途中でも読めるよう、ロックしてコピーを書き出す
 func (bc *Blockchain) MarshalJSON() ([]byte, error) {
+	bc.mux.Lock()
+	chain := make([]*Block, len(bc.chain))
+	copy(chain, bc.chain)
+	bc.mux.Unlock()
+
 	return json.Marshal(struct {
 		Blocks []*Block `json:"chains"`
 	}{
-		Blocks: bc.chain,
+		Blocks: chain,
 	})
 }
 
-// chainするBlockを作成してチェーンに追加
-// レシーバーのTransactionPoolの中身を、作成するBlockのTransactionsにいれて、レシーバーのPoolは空にする
-func (bc *Blockchain) CreateBlock(nonce int, previousHash [32]byte) *Block {
-	b := NewBlock(nonce, previousHash, bc.transactionPool)
+// チェーンの末尾にBlockを繋げ、ストレージがあれば保存する
+func (bc *Blockchain) appendBlock(b *Block) {
 	bc.chain = append(bc.chain, b)
-	bc.transactionPool = []*Transaction{}
-	return b
+	if bc.storage != nil {
+		if err := bc.storage.AppendBlock(b); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+	}
+
+	// 残高の増減はbの入力が使われる前の集合から求める
+	lookup := func(OutPoint) *TxOutput { return nil }
+	if bc.utxos != nil {
+		lookup = bc.utxos.Get
+	}
+	bc.indexBlock(b, lookup)
+	bc.nonces.push(b)
+	if bc.utxos != nil {
+		bc.utxos.apply(b)
+		bc.saveUTXOs()
+	}
+}
+
+// 次の高さのBlockとしてbを残高とアドレスのインデックスに反映する
+func (bc *Blockchain) indexBlock(b *Block, lookup func(OutPoint) *TxOutput) {
+	deltas := transactionDeltas(b.transactions, lookup)
+	bc.balances.push(sumDeltas(deltas))
+	bc.addresses.push(b, deltas)
+}
+
+// チェーンのfork番目以降が置き換わった時に、残高のインデックスとnonce、UTXOの集合を新しいチェーンに合わせる
+// 残高は古いチェーンのfork番目以降の分を巻き戻してから、新しいBlockの分を反映する
+// nonceは新しいチェーンをたどって作り直す
+// bc.muxをロックした状態で呼ぶこと
+func (bc *Blockchain) reindex(fork int) {
+	bc.balances.rollback(fork)
+	bc.addresses.rollback(fork)
+
+	// 各Blockの入力が指す出力を引くため、先頭から未使用の出力をたどる
+	state := newChainState()
+	for i, b := range bc.chain {
+		if i >= fork {
+			bc.indexBlock(b, func(op OutPoint) *TxOutput {
+				return state.unspent[op]
+			})
+		}
+		state.apply(b)
+	}
+	bc.nonces.reset(state.usedNonces)
+
+	if bc.utxos != nil && bc.utxos.Tip() != bc.LastBlock().Hash() {
+		bc.utxos.reset(state.unspent, bc.LastBlock().Hash())
+		bc.saveUTXOs()
+	}
+}
+
+// ストレージがあればUTXOの集合を保存する
+func (bc *Blockchain) saveUTXOs() {
+	if bc.storage == nil {
+		return
+	}
+	if err := bc.storage.SaveUTXOSet(bc.utxos); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+}
+
+// チェーンの最後のBlockまでを反映した検証用の状態
+// 残高とnonceはインデックスを、LEDGER_UTXOの場合は保存しているUTXOの集合を使い、チェーンをたどり直さない
+// bc.muxをロックした状態で呼ぶこと
+func (bc *Blockchain) tipState() *chainState {
+	state := newChainState()
+	state.confirmedBalances = bc.balances
+	state.confirmedNonces = bc.nonces
+	if bc.utxos != nil {
+		state.unspent = bc.utxos.snapshot()
+	}
+	return state
+}
+
+// 他のノードやインポートで受け取ったBlockを検証してチェーンに繋げる
+// 最後のBlockとの繋がり、Proof of Work、timestamp、署名と残高、Transactionの重複を確認し、
+// 問題があればvalidation.goで定義しているエラーを返す。取り込まれたものや無効になったTransactionはPoolから除く
+func (bc *Blockchain) AddBlock(b *Block) error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	state := bc.tipState()
+	if err := bc.validateBlock(bc.chain, b, state); err != nil {
+		log.Printf("ERROR: %v", err)
+		return err
+	}
+
+	bc.appendBlock(b)
+	bc.stopMining()
+	bc.pruneTransactionPool()
+	log.Println("action=add_block, status=success")
+	return nil
+}
+
+// 新しいチェーンの状態では取り込めなくなったTransactionをPoolから取り除く
+// Blockに含まれてnonceが使われたものや、残高が足りなくなったもの、ttlを過ぎたものが対象
+// bc.muxをロックした状態で呼ぶこと
+func (bc *Blockchain) pruneTransactionPool() {
+	state := bc.tipState()
+
+	var invalid []*Transaction
+	for _, t := range bc.transactionPool.Transactions() {
+		if t.IsUTXO() {
+			// 入力がすでに使われたものは取り込めない
+			if bc.validateUTXOTransaction(t, state.unspent) != nil {
+				invalid = append(invalid, t)
+				continue
+			}
+		} else if state.isNonceUsed(t.senderBlockchainAddress, t.nonce) ||
+			state.balance(t.senderBlockchainAddress) < t.value+t.fee {
+			invalid = append(invalid, t)
+			continue
+		}
+		state.applyTransaction(t)
+	}
+	bc.transactionPool.Remove(invalid...)
+	bc.saveTransactionPool()
+}
+
+// ストレージがあればtransactionPoolの現在の中身を保存する
+func (bc *Blockchain) saveTransactionPool() {
+	if bc.storage == nil {
+		return
+	}
+	if err := bc.storage.SaveTransactionPool(bc.transactionPool.Transactions()); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
 }
 
 // レシーバーのチェーンで繋がっている最後のブロックを取得
@@ -124,128 +506,506 @@ func (bc *Blockchain) Print() {
 	fmt.Printf("%s\n", strings.Repeat("*", 25))
 }
 
-func (bc *Blockchain) CreateTransaction(sender string, recipient string, value float32,
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	isTransacted := bc.AddTransaction(sender, recipient, value, senderPublicKey, s)
+func (bc *Blockchain) CreateTransaction(sender string, recipient string, value utils.Amount, fee utils.Amount,
+	nonce uint64, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) (*Transaction, error) {
+	t, err := bc.AddTransaction(sender, recipient, value, fee, nonce, senderPublicKey, s)
 
-	// TODO
-	// Sync
+	// 受け付けたTransactionを近隣ノードへ共有
+	if err == nil {
+		go bc.broadcastTransaction(sender, recipient, value, fee, nonce, senderPublicKey, s)
+	}
 
-	return isTransacted
+	return t, err
 }
 
 // 引数の情報を持つTransactionを新規作成、レシーバーのTransactionPoolに追加する
-func (bc *Blockchain) AddTransaction(sender string, recipient string, value float32,
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	t := NewTransaction(sender, recipient, value)
+// 追加したTransactionを返し、追加できない場合は理由をエラーで返す
+// feeはマイニングした人に支払われる手数料で、送金した人の残高からvalueと合わせて引かれる
+func (bc *Blockchain) AddTransaction(sender string, recipient string, value utils.Amount, fee utils.Amount,
+	nonce uint64, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) (*Transaction, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
 
-	// マイニングの際は、署名チェック不要
+	t := NewTransaction(sender, recipient, value, nonce)
+	t.fee = fee
+
+	// マイニング報酬はMiningの中でBlockに直接入れるので、Poolには受け付けない
 	if sender == MINING_SENDER {
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
+		log.Println("ERROR: Mining reward cannot be sent as a transaction")
+		return nil, ErrInvalidReward
 	}
 
-	if bc.VerifyTransactionSignature(senderPublicKey, s, t) {
-		// 所持残高が送金量に満たない時はtransaction追加処理を中止する
-		/*
-			if bc.CalculateTotalAmount(sender) < value {
-				log.Println("ERROR: Not enough balance in a wallet")
-				return false
-			}
-		*/
+	if value <= 0 {
+		log.Println("ERROR: Invalid value")
+		return nil, ErrInvalidValue
+	}
 
-		// transactionに追加
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
-	} else {
+	if fee < 0 {
+		log.Println("ERROR: Invalid fee")
+		return nil, ErrInvalidFee
+	}
+
+	// 送金額と手数料の合計。int64があふれて小さな値になると残高の確認を通ってしまうので、先に確かめる
+	required, err := utils.AddAmounts(value, fee)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+
+	if bc.ledger != LEDGER_ACCOUNT {
+		log.Println("ERROR: Account transaction on a UTXO ledger")
+		return nil, ErrLedgerMismatch
+	}
+
+	if !isAddressOf(sender, senderPublicKey) {
+		log.Println("ERROR: Public key does not match the sender")
+		return nil, ErrAddressMismatch
+	}
+
+	if !bc.VerifyTransactionSignature(senderPublicKey, s, t) {
 		log.Println("ERROR: Verify Transaction")
+		return nil, ErrInvalidSignature
+	}
+
+	// 同じnonceのTransactionがチェーンかPoolにあれば、再送されたものとして拒否する
+	if bc.isNonceUsed(sender, nonce) {
+		log.Println("ERROR: Nonce already used")
+		return nil, fmt.Errorf("%w: nonce %d already used", ErrDuplicateTransaction, nonce)
+	}
+
+	// 所持残高から、まだPoolにある自分の送金分を引いた額が送金量と手数料に満たない時はtransaction追加処理を中止する
+	available := bc.CalculateTotalAmount(sender) - bc.pendingAmount(sender)
+	if available < required {
+		log.Println("ERROR: Not enough balance in a wallet")
+		return nil, fmt.Errorf("%w: available %v, requested %v", ErrInsufficientBalance, available, required)
+	}
+
+	// 他のノードでも検証できるよう、公開鍵と署名をTransactionに持たせる
+	t.senderPublicKey = senderPublicKey
+	t.signature = s
+
+	// transactionに追加。Poolが一杯でtより手数料率の低いものがなければ拒否する
+	if err := bc.transactionPool.Add(t); err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+	bc.saveTransactionPool()
+	return t, nil
+}
+
+// 入力と出力を持つTransactionを作成してPoolに追加し、受け付けたものを近隣ノードへ共有する
+func (bc *Blockchain) CreateUTXOTransaction(inputs []*TxInput, outputs []*TxOutput, fee utils.Amount) (*Transaction, error) {
+	t, err := bc.AddUTXOTransaction(inputs, outputs, fee)
+	if err == nil {
+		go bc.broadcastUTXOTransaction(t)
+	}
+	return t, err
+}
+
+// 入力と出力を持つTransactionをLEDGER_UTXOのPoolに追加する
+// 入力はチェーンで未使用の出力で、Poolにある他のTransactionがまだ使っていないものでなければならない
+func (bc *Blockchain) AddUTXOTransaction(inputs []*TxInput, outputs []*TxOutput, fee utils.Amount) (*Transaction, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	if bc.ledger != LEDGER_UTXO {
+		log.Println("ERROR: UTXO transaction on an account ledger")
+		return nil, ErrLedgerMismatch
+	}
+
+	if fee < 0 {
+		log.Println("ERROR: Invalid fee")
+		return nil, ErrInvalidFee
+	}
+
+	t := NewUTXOTransaction(inputs, outputs, fee)
+
+	// 入力が指す出力だけを集合から取り出し、Poolで使われる予定のものは除く
+	unspent := make(map[OutPoint]*TxOutput)
+	for _, in := range inputs {
+		if out := bc.utxos.Get(in.previousOutput); out != nil {
+			unspent[in.previousOutput] = out
+		}
+	}
+	for _, p := range bc.transactionPool.Transactions() {
+		for _, in := range p.inputs {
+			delete(unspent, in.previousOutput)
+		}
+	}
+	if err := bc.validateUTXOTransaction(t, unspent); err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+
+	if err := bc.transactionPool.Add(t); err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+	bc.saveTransactionPool()
+	return t, nil
+}
+
+// 引数の人のnonceがチェーンかtransactionPoolですでに使われているか
+// チェーンの分はBlockを繋げるたびに更新しているnonceのインデックスから引く
+func (bc *Blockchain) isNonceUsed(blockchainAddress string, nonce uint64) bool {
+	if bc.nonces.IsUsed(blockchainAddress, nonce) {
+		return true
+	}
+	for _, t := range bc.transactionPool.TransactionsBySender(blockchainAddress) {
+		if t.nonce == nonce {
+			return true
+		}
 	}
 	return false
 }
 
+// transactionPoolにある、引数の人がまだ承認されていない送金と手数料の合計
+func (bc *Blockchain) pendingAmount(blockchainAddress string) utils.Amount {
+	var pending utils.Amount = 0
+	for _, t := range bc.transactionPool.TransactionsBySender(blockchainAddress) {
+		pending += t.value + t.fee
+	}
+	return pending
+}
+
 // 鍵、署名情報などを使って正当なトランザクション作成かをチェック
 func (bc *Blockchain) VerifyTransactionSignature(
 	serderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction) bool {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
+	if serderPublicKey == nil || s == nil {
+		return false
+	}
+	h := sha256.Sum256(t.SignedPayload())
 	return ecdsa.Verify(serderPublicKey, h[:], s.R, s.S)
 }
 
 func (bc *Blockchain) CopyTransactionPool() []*Transaction {
 	transactions := make([]*Transaction, 0)
-	for _, t := range bc.transactionPool {
-		transactions = append(transactions,
-			NewTransaction(
-				t.senderBlockchainAddress,
-				t.recipientBlockchainAddress,
-				t.value))
+	for _, t := range bc.transactionPool.Transactions() {
+		c := NewTransaction(
+			t.senderBlockchainAddress,
+			t.recipientBlockchainAddress,
+			t.value,
+			t.nonce)
+		c.fee = t.fee
+		c.inputs = t.inputs
+		c.outputs = t.outputs
+		c.senderPublicKey = t.senderPublicKey
+		c.signature = t.signature
+		transactions = append(transactions, c)
 	}
 	return transactions
 }
 
-// 成功したらtrue, 失敗したらfalseを返す。
-func (bc *Blockchain) ValidProof(nonce int, previousHash [32]byte, transactions []*Transaction, difficulty int) bool {
-	zeros := strings.Repeat("0", difficulty)
-	guessBlock := Block{0, nonce, previousHash, transactions}
-	guessHashStr := fmt.Sprintf("%x", guessBlock.Hash())
-	return guessHashStr[:difficulty] == zeros
-}
+// transactionsから手数料率(1byteあたりのfee)が高い順に、合計サイズがmaxSizeに収まるだけ選ぶ
+// 手数料率が同じ場合は先にPoolに入った方を優先する。選ばれなかったものはPoolに残る
+func selectTransactions(transactions []*Transaction, maxSize int) []*Transaction {
+	candidates := make([]*Transaction, len(transactions))
+	copy(candidates, transactions)
+	sizes := make(map[*Transaction]int, len(candidates))
+	for _, t := range candidates {
+		sizes[t] = t.Size()
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ti, tj := candidates[i], candidates[j]
+		return float64(ti.fee)/float64(sizes[ti]) > float64(tj.fee)/float64(sizes[tj])
+	})
 
-// レシーバーのnonceの適当な値が見つかるまでvalidProofを呼び続けるメソッド
-func (bc *Blockchain) ProofOfWork() int {
-	transactions := bc.CopyTransactionPool()
-	previousHash := bc.LastBlock().Hash()
-	nonce := 0
-	for !bc.ValidProof(nonce, previousHash, transactions, MINING_DIFFICULTY) {
-		nonce += 1
+	selected := make([]*Transaction, 0, len(candidates))
+	size := 0
+	for _, t := range candidates {
+		// 入らないものは飛ばし、より小さいTransactionで残りを埋める
+		if size+sizes[t] > maxSize {
+			continue
+		}
+		selected = append(selected, t)
+		size += sizes[t]
 	}
-	return nonce
+	return selected
 }
 
-// 新規BlockをChainするために必要となるMining処理全般を扱う。
-func (bc *Blockchain) Mining() bool {
+// 近隣ノードのチェーンを取得し、自分より累積の仕事量(ChainWork)が大きく正しいチェーンがあれば
+// 一番大きいものに置き換える。置き換えた場合はtrueを返す
+func (bc *Blockchain) ResolveConflicts() bool {
+	// 近隣ノードへの問い合わせはロックを外して行い、自分のチェーンより仕事量が小さいものはこの時点で除く
+	bc.mux.Lock()
+	work := ChainWork(bc.chain)
+	bc.mux.Unlock()
+
+	var chains [][]*Block
+	for _, n := range bc.Neighbors() {
+		endpoint := fmt.Sprintf("http://%s/", n)
+		resp, err := neighborClient.Get(endpoint)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			continue
+		}
+
+		var bcResp struct {
+			Blocks []*Block `json:"chains"`
+		}
+		err = json.NewDecoder(io.LimitReader(resp.Body, NEIGHBOR_MAX_CHAIN_SIZE)).Decode(&bcResp)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err != nil {
+			log.Printf("ERROR: failed to get chain from %s", n)
+			continue
+		}
+		// nullのBlockはChainWorkや検証で参照できないので、比べる前に除く
+		if i := nullBlockIndex(bcResp.Blocks); i >= 0 {
+			log.Printf("ERROR: chain from %s: %v: block %d is null", n, ErrMissingFields, i)
+			continue
+		}
+		if ChainWork(bcResp.Blocks).Cmp(work) > 0 {
+			chains = append(chains, bcResp.Blocks)
+		}
+	}
+
+	// 比べてから置き換えるまでの間に自分のチェーンが変わらないよう、検証から置き換えまでロックする
 	bc.mux.Lock()
 	defer bc.mux.Unlock()
 
-	// poolが空の時はマイニングしない
-	if len(bc.transactionPool) == 0 {
+	var bestChain []*Block = nil
+	maxWork := ChainWork(bc.chain)
+	for _, chain := range chains {
+		chainWork := ChainWork(chain)
+		if chainWork.Cmp(maxWork) <= 0 {
+			continue
+		}
+		if err := bc.validateChain(chain); err != nil {
+			log.Printf("ERROR: %v", err)
+			continue
+		}
+		maxWork = chainWork
+		bestChain = chain
+	}
+
+	if bestChain == nil {
+		log.Println("action=resolve_conflicts, status=not_replaced")
+		return false
+	}
+
+	// 古いチェーンと新しいチェーンが分かれた高さ
+	fork := 0
+	for fork < len(bc.chain) && bc.chain[fork].Hash() == bestChain[fork].Hash() {
+		fork += 1
+	}
+
+	orphaned := bc.chain[fork:]
+	bc.chain = bestChain
+	bc.stopMining()
+	if bc.storage != nil {
+		if err := bc.storage.ReplaceBlocks(bestChain); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+	}
+	bc.reindex(fork)
+	bc.restoreTransactions(orphaned)
+	bc.pruneTransactionPool()
+	log.Println("action=resolve_conflicts, status=replaced")
+	return true
+}
+
+// チェーンから外れたBlockのTransactionをPoolに戻す。マイニング報酬は戻さない
+// 新しいチェーンに含まれているものや、nonceや残高が合わなくなったものはこの後のpruneTransactionPoolで除く
+// bc.muxをロックした状態で呼ぶこと
+func (bc *Blockchain) restoreTransactions(orphaned []*Block) {
+	restored := 0
+	for _, b := range orphaned {
+		for _, t := range b.transactions {
+			if !t.IsUTXO() && t.senderBlockchainAddress == MINING_SENDER {
+				continue
+			}
+			if err := bc.transactionPool.Add(t); err != nil {
+				if !errors.Is(err, ErrDuplicateTransaction) {
+					log.Printf("ERROR: %v", err)
+				}
+				continue
+			}
+			restored += 1
+		}
+	}
+	log.Printf("action=restore_transactions, blocks=%d, transactions=%d", len(orphaned), restored)
+}
+
+// chainの中で最初にnilのBlockの位置。なければ-1
+func nullBlockIndex(chain []*Block) int {
+	for i, b := range chain {
+		if b == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// ヘッダーのハッシュを数値とみなし、difficultyから決まるtarget以下であれば成功としてtrue, 失敗したらfalseを返す。
+func (bc *Blockchain) ValidProof(h *BlockHeader) bool {
+	hash := h.Hash()
+	guess := new(big.Int).SetBytes(hash[:])
+	return guess.Cmp(DifficultyTarget(h.difficulty)) <= 0
+}
+
+// ヘッダーのnonceの適当な値が見つかるまでValidProofを呼び続けるメソッド
+// nonceをminingWorkers個のgoroutineで分担して探し、見つかったらb.header.nonceに入れてtrueを返す
+// ctxがキャンセルされた場合は途中でやめてfalseを返す
+func (bc *Blockchain) ProofOfWork(ctx context.Context, b *Block) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := bc.MiningWorkers()
+	found := make(chan int, workers)
+	var wg sync.WaitGroup
+	var hashes uint64
+	startedAt := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			// goroutineごとにヘッダーをコピーし、start, start+workers, ... のnonceを試す
+			h := b.header
+			var tried uint64
+			defer func() { atomic.AddUint64(&hashes, tried) }()
+			for h.nonce = start; ; h.nonce += workers {
+				if h.nonce%POW_CANCEL_CHECK_INTERVAL < workers {
+					select {
+					case <-ctx.Done():
+						return
+					default:
+					}
+				}
+				tried++
+				if bc.ValidProof(&h) {
+					found <- h.nonce
+					cancel()
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(found)
+
+	bc.mux.Lock()
+	bc.hashes += hashes
+	bc.hashingTime += time.Since(startedAt)
+	bc.mux.Unlock()
+
+	// 複数のgoroutineが同時に見つけた場合は最初に届いたものを使う
+	nonce, ok := <-found
+	if !ok {
 		return false
 	}
+	b.header.nonce = nonce
+	return true
+}
+
+// Proof of Workに使うgoroutineの数を設定する。0以下の場合はCPUの数にする
+func (bc *Blockchain) SetMiningWorkers(workers int) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.miningWorkers = workers
+}
+
+func (bc *Blockchain) MiningWorkers() int {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if bc.miningWorkers <= 0 {
+		return runtime.NumCPU()
+	}
+	return bc.miningWorkers
+}
+
+// 実行中のProof of Workをやめさせる。チェーンの最後のBlockが変わったときに呼ぶ
+// bc.muxをロックした状態で呼ぶこと
+func (bc *Blockchain) stopMining() {
+	if bc.cancelMining != nil {
+		bc.cancelMining()
+		bc.cancelMining = nil
+	}
+}
+
+// 新規BlockをChainするために必要となるMining処理全般を扱う。
+func (bc *Blockchain) Mining() bool {
+	return bc.MiningContext(context.Background())
+}
+
+// ctxを指定してMiningを行う。ctxがキャンセルされた場合は途中でやめてfalseを返す
+// Proof of Workの間はチェーンのロックを外し、その間に最後のBlockが変わった場合もやめてfalseを返す
+func (bc *Blockchain) MiningContext(ctx context.Context) bool {
+	// Miningは同時に1つだけ実行する
+	bc.muxMining.Lock()
+	defer bc.muxMining.Unlock()
+
+	bc.mux.Lock()
+	// poolが空でもマイニング報酬だけのBlockを作る
+	// 残高の元になるのはマイニング報酬だけなので、これがないと誰も送金を始められない
 
 	// マイニングした人へ送金するためのTransaction作成
-	bc.AddTransaction(MINING_SENDER, bc.blockchainAddress, MINING_REWARD, nil, nil)
+	// nonceには作成するBlockの高さを入れ、報酬ごとに別のTransactionになるようにする
+	// 報酬の額はPoolの手数料を全て受け取った場合で見積もり、そのサイズを除いた分にTransactionを詰める
+	pool := bc.CopyTransactionPool()
+	reward := NewTransaction(MINING_SENDER, bc.blockchainAddress, MINING_REWARD+totalFee(pool), uint64(len(bc.chain)))
+	transactions := selectTransactions(pool, MAX_BLOCK_SIZE-reward.Size())
+
+	// 選んだTransactionの手数料を報酬に加える
+	reward.value = MINING_REWARD + totalFee(transactions)
+	transactions = append(transactions, reward)
+	b := NewBlock(0, bc.LastBlock().Hash(), transactions)
+	b.header.difficulty = NextDifficulty(bc.chain)
+	// timestampは直前のBlockより後でなければならない。未来の時刻のBlockを受け取った後は、それより1だけ後にする
+	if minTimestamp := bc.LastBlock().header.timestamp + 1; b.header.timestamp < minTimestamp {
+		b.header.timestamp = minTimestamp
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	bc.cancelMining = cancel
+	bc.mux.Unlock()
 
-	nonce := bc.ProofOfWork()
-	previousHash := bc.LastBlock().Hash()
-	bc.CreateBlock(nonce, previousHash)
+	found := bc.ProofOfWork(ctx, b)
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.cancelMining = nil
+	if !found || b.header.previousHash != bc.LastBlock().Hash() {
+		log.Println("action=mining, status=canceled")
+		return false
+	}
+	// 他のノードが受け取る時と同じ検証をし、拒否されるBlockや再起動時に読み込めないBlockを保存しない
+	if err := bc.validateBlock(bc.chain, b, bc.tipState()); err != nil {
+		log.Printf("ERROR: %v", err)
+		return false
+	}
+
+	bc.appendBlock(b)
+	bc.pruneTransactionPool()
+	bc.blocksMined++
+	bc.lastMinedAt = time.Now()
 	log.Println("action=mining, status=success")
+
+	// マイニングしたBlockを近隣ノードへ共有し、より長いチェーンがないかを確認
+	go func() {
+		bc.broadcastBlock(b)
+		bc.ResolveConflicts()
+	}()
 	return true
 }
 
-// マイニングを自動で実行するための関数
-func (bc *Blockchain) StartMining() {
-	bc.Mining()
-	_ = time.AfterFunc(time.Second*MINING_TIMER_SEC, bc.StartMining)
+// 呼び出し時点のチェーン内で、引数の人がどれだけのValueを持っているかを返す。
+// Blockを繋げるたびに更新している残高のインデックスから引くので、チェーンの長さによらない
+func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) utils.Amount {
+	return bc.balances.Balance(blockchainAddress)
 }
 
-// 呼び出し時点のチェーン内で、引数の人がどれだけのValueを持っているかを返す。
-func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) float32 {
-	var totalAmount float32 = 0.0
-	for _, b := range bc.chain {
-		for _, t := range b.transactions {
-			value := t.value
-			// 受け取ったvalueは足し算
-			if blockchainAddress == t.recipientBlockchainAddress {
-				totalAmount += value
-			}
+// 承認済みの残高に、transactionPoolにある未承認のTransactionによる増減を加えたもの
+func (bc *Blockchain) CalculateUnconfirmedAmount(blockchainAddress string) utils.Amount {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
 
-			// 送信したvalueは引き算
-			if blockchainAddress == t.senderBlockchainAddress {
-				totalAmount -= value
-			}
-		}
+	lookup := func(OutPoint) *TxOutput { return nil }
+	if bc.utxos != nil {
+		lookup = bc.utxos.Get
 	}
-	return totalAmount
+	delta := balanceDelta(bc.transactionPool.Transactions(), lookup)
+	return bc.balances.Balance(blockchainAddress) + delta[blockchainAddress]
 }
 
 type Transaction struct {
@@ -256,60 +1016,349 @@ type Transaction struct {
 	recipientBlockchainAddress string
 
 	// 例：送金した内容（金額など）
-	value float32
+	value utils.Amount
+
+	// マイニングした人に支払う手数料。省略した場合は0
+	fee utils.Amount
+
+	// 同じ署名付きTransactionの再送を防ぐための番号。送金した人ごとに一度しか使えない
+	// マイニング報酬の場合はBlockの高さを入れる
+	nonce uint64
+
+	// 送金した人の公開鍵と署名（マイニング報酬の場合はnil）
+	senderPublicKey *ecdsa.PublicKey
+	signature       *utils.Signature
+
+	// LEDGER_UTXOの場合の入力と出力。この場合はsenderからnonceまでは使わない
+	inputs  []*TxInput
+	outputs []*TxOutput
 }
 
-func NewTransaction(sender string, recipient string, value float32) *Transaction {
-	return &Transaction{sender, recipient, value}
+func NewTransaction(sender string, recipient string, value utils.Amount, nonce uint64) *Transaction {
+	return &Transaction{
+		senderBlockchainAddress:    sender,
+		recipientBlockchainAddress: recipient,
+		value:                      value,
+		nonce:                      nonce,
+	}
 }
 
 func (t *Transaction) Print() {
 	fmt.Printf("%s\n", strings.Repeat("-", 40))
 	fmt.Printf(" sender_blockchain_address        %s\n", t.senderBlockchainAddress)
 	fmt.Printf(" recipient_blockchain_address     %s\n", t.recipientBlockchainAddress)
-	fmt.Printf(" value                            %.1f\n", t.value)
+	fmt.Printf(" value                            %s\n", t.value)
+	fmt.Printf(" fee                              %s\n", t.fee)
+	fmt.Printf(" nonce                            %d\n", t.nonce)
+	for _, in := range t.inputs {
+		fmt.Printf(" input                            %x:%d\n", in.previousOutput.TxHash, in.previousOutput.Index)
+	}
+	for _, out := range t.outputs {
+		fmt.Printf(" output                           %s %s\n", out.blockchainAddress, out.value)
+	}
+
+}
+
+// 署名の対象となるJson。wallet.TransactionのMarshalJSONと同じ形にする
+// feeが0の場合は含めないので、手数料なしのTransactionは以前と同じ署名になる
+func (t *Transaction) SignedPayload() []byte {
+	// 入力と出力を持つ場合は、入力が指す出力とtransactionの出力、feeを対象にする
+	if t.IsUTXO() {
+		previousOutputs := make([]OutPoint, 0, len(t.inputs))
+		for _, in := range t.inputs {
+			previousOutputs = append(previousOutputs, in.previousOutput)
+		}
+		m, _ := json.Marshal(struct {
+			Inputs  []OutPoint   `json:"inputs"`
+			Outputs []*TxOutput  `json:"outputs"`
+			Fee     utils.Amount `json:"fee,omitempty"`
+		}{
+			Inputs:  previousOutputs,
+			Outputs: t.outputs,
+			Fee:     t.fee,
+		})
+		return m
+	}
+
+	m, _ := json.Marshal(struct {
+		Sender    string       `json:"sender_blockchain_address"`
+		Recipient string       `json:"recipient_blockchain_address"`
+		Value     utils.Amount `json:"value"`
+		Fee       utils.Amount `json:"fee,omitempty"`
+		Nonce     uint64       `json:"nonce"`
+	}{
+		Sender:    t.senderBlockchainAddress,
+		Recipient: t.recipientBlockchainAddress,
+		Value:     t.value,
+		Fee:       t.fee,
+		Nonce:     t.nonce,
+	})
+	return m
+}
 
+// Blockの中で占めるサイズ(byte)。MarshalJSONで書き出したJsonの長さ
+func (t *Transaction) Size() int {
+	m, _ := json.Marshal(t)
+	return len(m)
+}
+
+// Transactionの手数料の合計
+func totalFee(transactions []*Transaction) utils.Amount {
+	var total utils.Amount = 0
+	for _, t := range transactions {
+		total += t.fee
+	}
+	return total
+}
+
+// Transactionを識別するハッシュ。署名の対象と同じ内容から計算するので、署名の前から決まる
+// 送金した人ごとにnonceは一度しか使えないため、チェーンの中で重複しない
+func (t *Transaction) Hash() [32]byte {
+	return sha256.Sum256(t.SignedPayload())
 }
 
 func (t *Transaction) MarshalJSON() ([]byte, error) {
+	var publicKey, signature string
+	if t.senderPublicKey != nil {
+		publicKey = publicKeyString(t.senderPublicKey)
+	}
+	if t.signature != nil {
+		signature = t.signature.String()
+	}
 	return json.Marshal(struct {
-		Sender    string  `json:"sender_blockchain_address"`
-		Recipient string  `json:"recipient_blockchain_address"`
-		Value     float32 `json:"value"`
+		Hash      string       `json:"hash"`
+		Sender    string       `json:"sender_blockchain_address,omitempty"`
+		Recipient string       `json:"recipient_blockchain_address,omitempty"`
+		Value     utils.Amount `json:"value,omitempty"`
+		Fee       utils.Amount `json:"fee"`
+		Nonce     uint64       `json:"nonce"`
+		PublicKey string       `json:"sender_public_key,omitempty"`
+		Signature string       `json:"signature,omitempty"`
+		Inputs    []*TxInput   `json:"inputs,omitempty"`
+		Outputs   []*TxOutput  `json:"outputs,omitempty"`
 	}{
+		Hash:      fmt.Sprintf("%x", t.Hash()),
 		Sender:    t.senderBlockchainAddress,
 		Recipient: t.recipientBlockchainAddress,
 		Value:     t.value,
+		Fee:       t.fee,
+		Nonce:     t.nonce,
+		PublicKey: publicKey,
+		Signature: signature,
+		Inputs:    t.inputs,
+		Outputs:   t.outputs,
 	})
 }
 
+// MarshalJSONで書き出したJsonからTransactionを復元する
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	v := struct {
+		Sender    string       `json:"sender_blockchain_address"`
+		Recipient string       `json:"recipient_blockchain_address"`
+		Value     utils.Amount `json:"value"`
+		Fee       utils.Amount `json:"fee"`
+		Nonce     uint64       `json:"nonce"`
+		PublicKey string       `json:"sender_public_key"`
+		Signature string       `json:"signature"`
+		Inputs    []*TxInput   `json:"inputs"`
+		Outputs   []*TxOutput  `json:"outputs"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	for i, in := range v.Inputs {
+		if in == nil {
+			return fmt.Errorf("%w: input %d is null", ErrMissingFields, i)
+		}
+	}
+	for i, out := range v.Outputs {
+		if out == nil {
+			return fmt.Errorf("%w: output %d is null", ErrMissingFields, i)
+		}
+	}
+	t.senderBlockchainAddress = v.Sender
+	t.recipientBlockchainAddress = v.Recipient
+	t.value = v.Value
+	t.fee = v.Fee
+	t.nonce = v.Nonce
+	t.inputs = v.Inputs
+	t.outputs = v.Outputs
+	t.senderPublicKey = nil
+	t.signature = nil
+	var err error
+	if v.PublicKey != "" {
+		if t.senderPublicKey, err = utils.PublicKeyFromString(v.PublicKey); err != nil {
+			return err
+		}
+	}
+	if v.Signature != "" {
+		if t.signature, err = utils.SignatureFromString(v.Signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 公開鍵をX, Yそれぞれ64桁の16進数を繋げた文字列にする
+func publicKeyString(publicKey *ecdsa.PublicKey) string {
+	return fmt.Sprintf("%064x%064x", publicKey.X, publicKey.Y)
+}
+
+const (
+	TRANSACTION_STATUS_PENDING   = "pending"
+	TRANSACTION_STATUS_CONFIRMED = "confirmed"
+	TRANSACTION_STATUS_UNKNOWN   = "unknown"
+)
+
+// ハッシュでTransactionを探した結果
+// confirmedの場合は取り込まれたBlockの高さ(genesisが0)と、そのBlockを含めた承認数を持つ
+type TransactionLookup struct {
+	Hash          [32]byte
+	Status        string
+	Transaction   *Transaction
+	BlockHeight   int
+	Confirmations int
+}
+
+func (tl *TransactionLookup) MarshalJSON() ([]byte, error) {
+	var blockHeight *int
+	if tl.Status == TRANSACTION_STATUS_CONFIRMED {
+		blockHeight = &tl.BlockHeight
+	}
+	return json.Marshal(struct {
+		Hash          string       `json:"hash"`
+		Status        string       `json:"status"`
+		Transaction   *Transaction `json:"transaction,omitempty"`
+		BlockHeight   *int         `json:"block_height,omitempty"`
+		Confirmations int          `json:"confirmations"`
+	}{
+		Hash:          fmt.Sprintf("%x", tl.Hash),
+		Status:        tl.Status,
+		Transaction:   tl.Transaction,
+		BlockHeight:   blockHeight,
+		Confirmations: tl.Confirmations,
+	})
+}
+
+// ハッシュに一致するTransactionをチェーンの新しい方とtransactionPoolから探す
+func (bc *Blockchain) LookupTransaction(hash [32]byte) *TransactionLookup {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	for height := len(bc.chain) - 1; height >= 0; height-- {
+		for _, t := range bc.chain[height].transactions {
+			if t.Hash() == hash {
+				return &TransactionLookup{
+					Hash:          hash,
+					Status:        TRANSACTION_STATUS_CONFIRMED,
+					Transaction:   t,
+					BlockHeight:   height,
+					Confirmations: len(bc.chain) - height,
+				}
+			}
+		}
+	}
+	if t := bc.transactionPool.Get(hash); t != nil {
+		return &TransactionLookup{Hash: hash, Status: TRANSACTION_STATUS_PENDING, Transaction: t}
+	}
+	return &TransactionLookup{Hash: hash, Status: TRANSACTION_STATUS_UNKNOWN}
+}
+
 type TransactionRequest struct {
-	SenderBlockchainAddress    *string  `json:"sender_blockchain_address"`
-	RecipientBlockchainAddress *string  `json:"recipient_blockchain_address"`
-	SenderPublicKey            *string  `json:"sender_public_key"`
-	Value                      *float32 `json:"value"`
-	Signature                  *string  `json:"signature"`
+	SenderBlockchainAddress    *string       `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress *string       `json:"recipient_blockchain_address"`
+	SenderPublicKey            *string       `json:"sender_public_key"`
+	Value                      *utils.Amount `json:"value"`
+	Fee                        *utils.Amount `json:"fee,omitempty"`
+	Nonce                      *uint64       `json:"nonce"`
+	Signature                  *string       `json:"signature"`
+
+	// LEDGER_UTXOの場合はsenderからsignatureまでの代わりに入力と出力を送る
+	Inputs  []*TxInput  `json:"inputs,omitempty"`
+	Outputs []*TxOutput `json:"outputs,omitempty"`
 }
 
-func (tr *TransactionRequest) Validate() bool {
+// 入力と出力を持つTransactionのRequestかどうか
+func (tr *TransactionRequest) IsUTXO() bool {
+	return tr.Inputs != nil || tr.Outputs != nil
+}
+
+// 必要な項目が揃っているか、公開鍵と署名が読み込めるか、
+// アドレスが正しい形式で送金する人のアドレスが公開鍵から求めたものかを確かめる
+// 署名と残高はAddTransactionで確かめる
+func (tr *TransactionRequest) Validate() error {
+	if tr.IsUTXO() {
+		if len(tr.Inputs) == 0 || len(tr.Outputs) == 0 {
+			return ErrMissingFields
+		}
+		for _, in := range tr.Inputs {
+			if in == nil {
+				return ErrMissingFields
+			}
+		}
+		for _, out := range tr.Outputs {
+			if out == nil {
+				return ErrMissingFields
+			}
+			if err := wallet.ValidateAddress(out.blockchainAddress); err != nil {
+				return fmt.Errorf("output: %w", err)
+			}
+		}
+		return nil
+	}
 	if tr.SenderBlockchainAddress == nil ||
 		tr.RecipientBlockchainAddress == nil ||
 		tr.SenderPublicKey == nil ||
 		tr.Value == nil ||
+		tr.Nonce == nil ||
 		tr.Signature == nil {
-		return false
+		return ErrMissingFields
 	}
-	return true
+	if err := wallet.ValidateAddress(*tr.RecipientBlockchainAddress); err != nil {
+		return fmt.Errorf("recipient: %w", err)
+	}
+	publicKey, _, err := tr.PublicKeyAndSignature()
+	if err != nil {
+		return err
+	}
+	if err := wallet.VerifyAddress(*tr.SenderBlockchainAddress, publicKey); err != nil {
+		return fmt.Errorf("sender: %w", err)
+	}
+	return nil
+}
+
+// sender_public_keyとsignatureを読み込む。形式が正しくない場合や公開鍵がP-256の曲線上にない場合はエラーを返す
+func (tr *TransactionRequest) PublicKeyAndSignature() (*ecdsa.PublicKey, *utils.Signature, error) {
+	publicKey, err := utils.PublicKeyFromString(*tr.SenderPublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := utils.SignatureFromString(*tr.Signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	return publicKey, signature, nil
+}
+
+// feeは省略できる。省略された場合は0
+func (tr *TransactionRequest) FeeAmount() utils.Amount {
+	if tr.Fee == nil {
+		return 0
+	}
+	return *tr.Fee
 }
 
+// UnconfirmedAmountはtransactionPoolの分も含めた残高。求められた場合だけ返す
 type AmountResponse struct {
-	Amount float32 `json:"amount"`
+	Amount            utils.Amount  `json:"amount"`
+	UnconfirmedAmount *utils.Amount `json:"unconfirmed_amount,omitempty"`
 }
 
 func (ar AmountResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Amount float32 `json:"amount"`
+		Amount            utils.Amount  `json:"amount"`
+		UnconfirmedAmount *utils.Amount `json:"unconfirmed_amount,omitempty"`
 	}{
-		Amount: ar.Amount,
+		Amount:            ar.Amount,
+		UnconfirmedAmount: ar.UnconfirmedAmount,
 	})
 }
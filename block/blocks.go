@@ -0,0 +1,80 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// 1回のBlocksで返す件数の既定値と上限
+	BLOCKS_DEFAULT_LIMIT = 20
+	BLOCKS_MAX_LIMIT     = 100
+)
+
+// チェーンの中でのBlockの位置とハッシュを付けたもの。APIで1つのBlockを返す時に使う
+type BlockInfo struct {
+	Block  *Block
+	Height int
+}
+
+func (bi *BlockInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Hash         string         `json:"hash"`
+		Height       int            `json:"height"`
+		Header       *BlockHeader   `json:"header"`
+		Transactions []*Transaction `json:"transactions"`
+	}{
+		Hash:         fmt.Sprintf("%x", bi.Block.Hash()),
+		Height:       bi.Height,
+		Header:       &bi.Block.header,
+		Transactions: bi.Block.transactions,
+	})
+}
+
+// 高さ(genesisが0)を指定してBlockを返す。範囲外の場合はnil
+func (bc *Blockchain) BlockByHeight(height int) *BlockInfo {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if height < 0 || height >= len(bc.chain) {
+		return nil
+	}
+	return &BlockInfo{Block: bc.chain[height], Height: height}
+}
+
+// ハッシュが一致するBlockをチェーンの新しい方から探す。見つからなければnil
+func (bc *Blockchain) BlockByHash(hash [32]byte) *BlockInfo {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	for height := len(bc.chain) - 1; height >= 0; height-- {
+		if bc.chain[height].Hash() == hash {
+			return &BlockInfo{Block: bc.chain[height], Height: height}
+		}
+	}
+	return nil
+}
+
+// チェーンの最後のBlockを返す
+func (bc *Blockchain) LatestBlock() *BlockInfo {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return &BlockInfo{Block: bc.LastBlock(), Height: len(bc.chain) - 1}
+}
+
+// 高さfromからlimit個のBlockを古い順に返す。2つ目の戻り値は取得した時点のチェーンの長さ
+// limitが0以下の場合はBLOCKS_DEFAULT_LIMIT個、上限はBLOCKS_MAX_LIMIT個
+func (bc *Blockchain) Blocks(from int, limit int) ([]*BlockInfo, int) {
+	if limit <= 0 {
+		limit = BLOCKS_DEFAULT_LIMIT
+	}
+	if limit > BLOCKS_MAX_LIMIT {
+		limit = BLOCKS_MAX_LIMIT
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	blocks := make([]*BlockInfo, 0, limit)
+	for height := from; height >= 0 && height < len(bc.chain) && len(blocks) < limit; height++ {
+		blocks = append(blocks, &BlockInfo{Block: bc.chain[height], Height: height})
+	}
+	return blocks, len(bc.chain)
+}
@@ -0,0 +1,79 @@
+package block
+
+import (
+	"math/big"
+)
+
+const (
+	// 何Blockごとに難易度を調整するか
+	DIFFICULTY_ADJUSTMENT_INTERVAL = 10
+	// 1回の調整で難易度を変えられる最大の倍率
+	DIFFICULTY_MAX_ADJUSTMENT_FACTOR = 4
+	MIN_DIFFICULTY                   = 1
+)
+
+// ハッシュが取りうる最大の値(2^256 - 1)
+var maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// 難易度からtargetを計算する。ヘッダーのハッシュがこれ以下になればProof of Work成功
+// 難易度が2倍になるとtargetは半分になる
+func DifficultyTarget(difficulty uint64) *big.Int {
+	if difficulty < MIN_DIFFICULTY {
+		difficulty = MIN_DIFFICULTY
+	}
+	return new(big.Int).Div(maxTarget, new(big.Int).SetUint64(difficulty))
+}
+
+// chainの次に繋げるBlockの難易度
+// DIFFICULTY_ADJUSTMENT_INTERVALごとに、直前の区間で実際にかかった時間と
+// MINING_TIMER_SECから決まる目標の時間を比べて調整し、それ以外は直前のBlockと同じにする
+func NextDifficulty(chain []*Block) uint64 {
+	height := len(chain)
+	last := chain[height-1]
+	if height%DIFFICULTY_ADJUSTMENT_INTERVAL != 0 {
+		return last.header.difficulty
+	}
+
+	// genesisのtimestampは固定なので、最初の区間はその次のBlockから数える
+	firstIndex := height - 1 - DIFFICULTY_ADJUSTMENT_INTERVAL
+	if firstIndex < 1 {
+		firstIndex = 1
+	}
+	intervals := int64(height - 1 - firstIndex)
+	if intervals == 0 {
+		return last.header.difficulty
+	}
+
+	expected := intervals * MINING_TIMER_SEC * 1e9
+	actual := last.header.timestamp - chain[firstIndex].header.timestamp
+
+	// 極端な値で難易度が一気に変わらないよう、調整の幅を制限する
+	if actual < expected/DIFFICULTY_MAX_ADJUSTMENT_FACTOR {
+		actual = expected / DIFFICULTY_MAX_ADJUSTMENT_FACTOR
+	}
+	if actual > expected*DIFFICULTY_MAX_ADJUSTMENT_FACTOR {
+		actual = expected * DIFFICULTY_MAX_ADJUSTMENT_FACTOR
+	}
+
+	// 新しい難易度 = 今の難易度 * 目標の時間 / 実際の時間
+	next := new(big.Int).SetUint64(last.header.difficulty)
+	next.Mul(next, big.NewInt(expected))
+	next.Div(next, big.NewInt(actual))
+	if !next.IsUint64() {
+		return ^uint64(0)
+	}
+	if next.Uint64() < MIN_DIFFICULTY {
+		return MIN_DIFFICULTY
+	}
+	return next.Uint64()
+}
+
+// チェーンの累積の仕事量。各Blockの難易度の合計で、ハッシュを試す回数の期待値に比例する
+// Blockの数ではなくこれを比べることで、難易度を下げて作った長いチェーンに置き換えられないようにする
+func ChainWork(chain []*Block) *big.Int {
+	work := new(big.Int)
+	for _, b := range chain {
+		work.Add(work, new(big.Int).SetUint64(b.header.difficulty))
+	}
+	return work
+}
@@ -0,0 +1,210 @@
+package block
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Poolに置いておけるTransactionの数とJsonの合計サイズ(byte)
+	MEMPOOL_MAX_TRANSACTIONS = 5000
+	MEMPOOL_MAX_BYTES        = 5 * MAX_BLOCK_SIZE
+
+	// これより長くPoolに残ったTransactionは取り込まれないものとして捨てる
+	MEMPOOL_TRANSACTION_TTL = 24 * time.Hour
+)
+
+var ErrMempoolFull = errors.New("transaction pool is full")
+
+type mempoolEntry struct {
+	transaction *Transaction
+	hash        [32]byte
+	size        int
+	addedAt     time.Time
+	// 受け付けた順番。Transactionsはこの順に返す
+	seq uint64
+}
+
+// 未承認のTransactionを置いておくPool
+// ハッシュと送金した人で引けるようにし、数とサイズの上限を超える場合は手数料率の低いものから追い出す
+// 全てのメソッドは複数のgoroutineから呼んでよい
+type Mempool struct {
+	mux      sync.Mutex
+	entries  map[[32]byte]*mempoolEntry
+	bySender map[string]map[[32]byte]*mempoolEntry
+	bytes    int
+	seq      uint64
+
+	maxTransactions int
+	maxBytes        int
+	ttl             time.Duration
+}
+
+func NewMempool(maxTransactions int, maxBytes int, ttl time.Duration) *Mempool {
+	return &Mempool{
+		entries:         make(map[[32]byte]*mempoolEntry),
+		bySender:        make(map[string]map[[32]byte]*mempoolEntry),
+		maxTransactions: maxTransactions,
+		maxBytes:        maxBytes,
+		ttl:             ttl,
+	}
+}
+
+// Transactionを追加する。同じハッシュのものがあればErrDuplicateTransactionを返す
+// 上限を超える場合は手数料率の低いものから追い出し、tより低いものでは空きが足りなければErrMempoolFullを返す
+func (mp *Mempool) Add(t *Transaction) error {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	mp.expire()
+
+	hash := t.Hash()
+	if _, ok := mp.entries[hash]; ok {
+		return ErrDuplicateTransaction
+	}
+	e := &mempoolEntry{transaction: t, hash: hash, size: t.Size(), addedAt: time.Now()}
+
+	// 追い出すものを先に決め、足りない場合は何も変えずに拒否する
+	var evicted []*mempoolEntry
+	count, bytes := len(mp.entries)+1, mp.bytes+e.size
+	if count > mp.maxTransactions || bytes > mp.maxBytes {
+		for _, victim := range mp.byFeeRate() {
+			if count <= mp.maxTransactions && bytes <= mp.maxBytes {
+				break
+			}
+			if !lowerFeeRate(victim, e) {
+				return ErrMempoolFull
+			}
+			evicted = append(evicted, victim)
+			count -= 1
+			bytes -= victim.size
+		}
+		if count > mp.maxTransactions || bytes > mp.maxBytes {
+			return ErrMempoolFull
+		}
+	}
+	for _, victim := range evicted {
+		mp.remove(victim)
+	}
+
+	mp.seq += 1
+	e.seq = mp.seq
+	mp.entries[hash] = e
+	sender := t.senderBlockchainAddress
+	if mp.bySender[sender] == nil {
+		mp.bySender[sender] = make(map[[32]byte]*mempoolEntry)
+	}
+	mp.bySender[sender][hash] = e
+	mp.bytes += e.size
+	return nil
+}
+
+// 引数のTransactionをPoolから除く。Poolにないものは無視する
+func (mp *Mempool) Remove(transactions ...*Transaction) {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	for _, t := range transactions {
+		if e, ok := mp.entries[t.Hash()]; ok {
+			mp.remove(e)
+		}
+	}
+}
+
+// ハッシュが一致するTransactionを返す。なければnil
+func (mp *Mempool) Get(hash [32]byte) *Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	mp.expire()
+	if e, ok := mp.entries[hash]; ok {
+		return e.transaction
+	}
+	return nil
+}
+
+// Poolにある全てのTransactionを受け付けた順に返す
+func (mp *Mempool) Transactions() []*Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	mp.expire()
+	return sortedTransactions(mp.entries)
+}
+
+// 引数の人が送金したTransactionを受け付けた順に返す
+func (mp *Mempool) TransactionsBySender(blockchainAddress string) []*Transaction {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	mp.expire()
+	return sortedTransactions(mp.bySender[blockchainAddress])
+}
+
+func (mp *Mempool) Len() int {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	mp.expire()
+	return len(mp.entries)
+}
+
+// Poolにある全てのTransactionのJsonの合計サイズ(byte)
+func (mp *Mempool) Bytes() int {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+	mp.expire()
+	return mp.bytes
+}
+
+// ttlより長くPoolにあるものを捨てる。mp.muxをロックした状態で呼ぶこと
+func (mp *Mempool) expire() {
+	deadline := time.Now().Add(-mp.ttl)
+	for _, e := range mp.entries {
+		if e.addedAt.Before(deadline) {
+			mp.remove(e)
+		}
+	}
+}
+
+// mp.muxをロックした状態で呼ぶこと
+func (mp *Mempool) remove(e *mempoolEntry) {
+	sender := e.transaction.senderBlockchainAddress
+	delete(mp.entries, e.hash)
+	delete(mp.bySender[sender], e.hash)
+	if len(mp.bySender[sender]) == 0 {
+		delete(mp.bySender, sender)
+	}
+	mp.bytes -= e.size
+}
+
+// 手数料率の低い順。同じ場合は後から受け付けたものを先にする
+func (mp *Mempool) byFeeRate() []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(mp.entries))
+	for _, e := range mp.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if lowerFeeRate(a, b) || lowerFeeRate(b, a) {
+			return lowerFeeRate(a, b)
+		}
+		return a.seq > b.seq
+	})
+	return entries
+}
+
+func lowerFeeRate(a, b *mempoolEntry) bool {
+	return float64(a.transaction.fee)/float64(a.size) < float64(b.transaction.fee)/float64(b.size)
+}
+
+func sortedTransactions(entries map[[32]byte]*mempoolEntry) []*Transaction {
+	sorted := make([]*mempoolEntry, 0, len(entries))
+	for _, e := range entries {
+		sorted = append(sorted, e)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].seq < sorted[j].seq
+	})
+	transactions := make([]*Transaction, 0, len(sorted))
+	for _, e := range sorted {
+		transactions = append(transactions, e.transaction)
+	}
+	return transactions
+}
@@ -0,0 +1,208 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	MERKLE_POSITION_LEFT  = "left"
+	MERKLE_POSITION_RIGHT = "right"
+)
+
+// マークルツリーの親ノードのハッシュ
+// Transactionのハッシュ(葉)と区別するため、先頭に0x01を付けてからハッシュ化する
+func merkleParent(left [32]byte, right [32]byte) [32]byte {
+	buf := make([]byte, 0, 1+len(left)+len(right))
+	buf = append(buf, 0x01)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return sha256.Sum256(buf)
+}
+
+// 1つ上の段のノードを計算する
+// 2つずつ組にし、奇数個で余った最後のノードはそのまま上の段へ上げる
+func merkleNextLevel(level [][32]byte) [][32]byte {
+	next := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, merkleParent(level[i], level[i+1]))
+		} else {
+			next = append(next, level[i])
+		}
+	}
+	return next
+}
+
+// ハッシュの一覧からマークルルートを計算する
+// 空の場合はすべて0
+func MerkleRoot(hashes [][32]byte) [32]byte {
+	if len(hashes) == 0 {
+		return [32]byte{}
+	}
+	level := hashes
+	for len(level) > 1 {
+		level = merkleNextLevel(level)
+	}
+	return level[0]
+}
+
+// Transactionのハッシュを葉にしたマークルルート
+func TransactionsMerkleRoot(transactions []*Transaction) [32]byte {
+	return MerkleRoot(transactionHashes(transactions))
+}
+
+func transactionHashes(transactions []*Transaction) [][32]byte {
+	hashes := make([][32]byte, len(transactions))
+	for i, t := range transactions {
+		hashes[i] = t.Hash()
+	}
+	return hashes
+}
+
+// マークルルートまでたどるときの1段分。兄弟ノードのハッシュと、それが左右どちらにあるか
+type MerkleProofStep struct {
+	Hash     [32]byte
+	Position string
+}
+
+func (s *MerkleProofStep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Hash     string `json:"hash"`
+		Position string `json:"position"`
+	}{
+		Hash:     fmt.Sprintf("%x", s.Hash),
+		Position: s.Position,
+	})
+}
+
+func (s *MerkleProofStep) UnmarshalJSON(data []byte) error {
+	v := struct {
+		Hash     string `json:"hash"`
+		Position string `json:"position"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Position != MERKLE_POSITION_LEFT && v.Position != MERKLE_POSITION_RIGHT {
+		return fmt.Errorf("invalid position: %q", v.Position)
+	}
+	s.Position = v.Position
+	return decodeHash(v.Hash, &s.Hash)
+}
+
+// hashes[index]からマークルルートまでの証明を作る
+func MerkleProof(hashes [][32]byte, index int) []*MerkleProofStep {
+	if index < 0 || index >= len(hashes) {
+		return nil
+	}
+	proof := make([]*MerkleProofStep, 0)
+	level := hashes
+	for len(level) > 1 {
+		// 奇数個で余ったノードは兄弟がいないので、この段は証明に含めない
+		if index%2 == 0 && index+1 < len(level) {
+			proof = append(proof, &MerkleProofStep{Hash: level[index+1], Position: MERKLE_POSITION_RIGHT})
+		} else if index%2 == 1 {
+			proof = append(proof, &MerkleProofStep{Hash: level[index-1], Position: MERKLE_POSITION_LEFT})
+		}
+
+		index /= 2
+		level = merkleNextLevel(level)
+	}
+	return proof
+}
+
+// hashから証明をたどって計算したマークルルートがrootと一致するかを確認する
+func VerifyMerkleProof(hash [32]byte, proof []*MerkleProofStep, root [32]byte) bool {
+	current := hash
+	for _, step := range proof {
+		switch step.Position {
+		case MERKLE_POSITION_LEFT:
+			current = merkleParent(step.Hash, current)
+		case MERKLE_POSITION_RIGHT:
+			current = merkleParent(current, step.Hash)
+		default:
+			return false
+		}
+	}
+	return current == root
+}
+
+// Transactionがあるブロックに含まれていることの証明
+// ヘッダーだけを持っていれば、Blockの全体がなくても検証できる
+type InclusionProof struct {
+	TransactionHash [32]byte
+	BlockHeight     int
+	Header          *BlockHeader
+	Proof           []*MerkleProofStep
+}
+
+// 証明がヘッダーのマークルルートと一致するかを確認する
+func (ip *InclusionProof) Verify() bool {
+	return VerifyTransactionInclusion(ip.Header, ip.TransactionHash, ip.Proof)
+}
+
+// Transactionのハッシュと証明がヘッダーのマークルルートに繋がるかを確認する
+func VerifyTransactionInclusion(header *BlockHeader, transactionHash [32]byte, proof []*MerkleProofStep) bool {
+	if header == nil {
+		return false
+	}
+	return VerifyMerkleProof(transactionHash, proof, header.merkleRoot)
+}
+
+func (ip *InclusionProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TransactionHash string             `json:"transaction_hash"`
+		BlockHeight     int                `json:"block_height"`
+		BlockHash       string             `json:"block_hash"`
+		Header          *BlockHeader       `json:"header"`
+		Proof           []*MerkleProofStep `json:"proof"`
+	}{
+		TransactionHash: fmt.Sprintf("%x", ip.TransactionHash),
+		BlockHeight:     ip.BlockHeight,
+		BlockHash:       fmt.Sprintf("%x", ip.Header.Hash()),
+		Header:          ip.Header,
+		Proof:           ip.Proof,
+	})
+}
+
+func (ip *InclusionProof) UnmarshalJSON(data []byte) error {
+	v := struct {
+		TransactionHash string             `json:"transaction_hash"`
+		BlockHeight     int                `json:"block_height"`
+		Header          *BlockHeader       `json:"header"`
+		Proof           []*MerkleProofStep `json:"proof"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := decodeHash(v.TransactionHash, &ip.TransactionHash); err != nil {
+		return fmt.Errorf("transaction_hash: %v", err)
+	}
+	ip.BlockHeight = v.BlockHeight
+	ip.Header = v.Header
+	ip.Proof = v.Proof
+	return nil
+}
+
+// チェーンに取り込まれたTransactionの証明を作る。見つからない場合はnilを返す
+func (bc *Blockchain) InclusionProof(hash [32]byte) *InclusionProof {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	for height := len(bc.chain) - 1; height >= 0; height-- {
+		b := bc.chain[height]
+		hashes := transactionHashes(b.transactions)
+		for i, h := range hashes {
+			if h == hash {
+				return &InclusionProof{
+					TransactionHash: hash,
+					BlockHeight:     height,
+					Header:          &b.header,
+					Proof:           MerkleProof(hashes, i),
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package block
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func testHashes(n int) [][32]byte {
+	hashes := make([][32]byte, n)
+	for i := range hashes {
+		hashes[i] = sha256.Sum256([]byte(fmt.Sprintf("tx%d", i)))
+	}
+	return hashes
+}
+
+func TestMerkleRoot(t *testing.T) {
+	h := testHashes(3)
+	tests := []struct {
+		name   string
+		hashes [][32]byte
+		want   [32]byte
+	}{
+		{"empty", nil, [32]byte{}},
+		{"one", h[:1], h[0]},
+		{"two", h[:2], merkleParent(h[0], h[1])},
+		// 奇数個で余った最後のノードはそのまま上の段へ上げる
+		{"three", h[:3], merkleParent(merkleParent(h[0], h[1]), h[2])},
+	}
+	for _, tt := range tests {
+		if got := MerkleRoot(tt.hashes); got != tt.want {
+			t.Errorf("%s: MerkleRoot() = %x, want %x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 6, 7, 9} {
+		hashes := testHashes(n)
+		root := MerkleRoot(hashes)
+		for i := range hashes {
+			proof := MerkleProof(hashes, i)
+			if !VerifyMerkleProof(hashes[i], proof, root) {
+				t.Errorf("n=%d index=%d: proof does not verify", n, i)
+			}
+			// 別の葉のハッシュでは同じ証明は通らない
+			other := hashes[(i+1)%n]
+			if n > 1 && VerifyMerkleProof(other, proof, root) {
+				t.Errorf("n=%d index=%d: proof verifies for another leaf", n, i)
+			}
+		}
+	}
+}
+
+func TestMerkleProofOutOfRange(t *testing.T) {
+	hashes := testHashes(3)
+	for _, index := range []int{-1, 3} {
+		if proof := MerkleProof(hashes, index); proof != nil {
+			t.Errorf("MerkleProof(%d) = %v, want nil", index, proof)
+		}
+	}
+}
+
+func TestVerifyMerkleProofTampered(t *testing.T) {
+	hashes := testHashes(5)
+	root := MerkleRoot(hashes)
+	tests := []struct {
+		name   string
+		modify func(proof []*MerkleProofStep)
+	}{
+		{"hash", func(proof []*MerkleProofStep) { proof[0].Hash[0] ^= 0xff }},
+		{"position", func(proof []*MerkleProofStep) { proof[0].Position = MERKLE_POSITION_LEFT }},
+		{"unknown position", func(proof []*MerkleProofStep) { proof[0].Position = "up" }},
+	}
+	for _, tt := range tests {
+		proof := MerkleProof(hashes, 0)
+		tt.modify(proof)
+		if VerifyMerkleProof(hashes[0], proof, root) {
+			t.Errorf("%s: tampered proof verifies", tt.name)
+		}
+	}
+}
+
+func TestVerifyTransactionInclusion(t *testing.T) {
+	transactions := []*Transaction{
+		NewTransaction("A", "B", 1, 0),
+		NewTransaction("B", "C", 2, 0),
+		NewTransaction(MINING_SENDER, "A", MINING_REWARD, 1),
+	}
+	b := NewBlock(0, [32]byte{}, transactions)
+	hashes := transactionHashes(transactions)
+	for i, h := range hashes {
+		if !VerifyTransactionInclusion(&b.header, h, MerkleProof(hashes, i)) {
+			t.Errorf("transaction %d: inclusion does not verify", i)
+		}
+	}
+	if VerifyTransactionInclusion(nil, hashes[0], MerkleProof(hashes, 0)) {
+		t.Error("inclusion verifies without a header")
+	}
+}
@@ -0,0 +1,135 @@
+package block
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// 一定間隔でMiningを自動実行するためのコントローラー
+// Start, Stopは何度呼んでも実行中のループは1つだけになる
+type Miner struct {
+	blockchain *Blockchain
+	interval   time.Duration
+
+	mux    sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewMiner(bc *Blockchain, interval time.Duration) *Miner {
+	return &Miner{blockchain: bc, interval: interval}
+}
+
+func (m *Miner) Interval() time.Duration {
+	return m.interval
+}
+
+func (m *Miner) Running() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.cancel != nil
+}
+
+// 自動マイニングを開始する。既に実行中の場合は何もせずfalseを返す
+func (m *Miner) Start() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.cancel != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.run(ctx, m.done)
+	log.Println("action=miner, status=started")
+	return true
+}
+
+// 自動マイニングを止め、実行中のProof of Workが終わるまで待つ。実行中でなければfalseを返す
+// 待っている間もStatusを返せるよう、m.muxはループを止める指示を出すところまでしかロックしない
+func (m *Miner) Stop() bool {
+	m.mux.Lock()
+	if m.cancel == nil {
+		m.mux.Unlock()
+		return false
+	}
+	m.cancel()
+	done := m.done
+	m.cancel = nil
+	m.done = nil
+	m.mux.Unlock()
+
+	<-done
+	log.Println("action=miner, status=stopped")
+	return true
+}
+
+func (m *Miner) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		// poolが空でもマイニングする。送金の元になる残高はマイニング報酬からしか生まれないため
+		m.blockchain.MiningContext(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// マイニングの状態を返す。採掘数やハッシュレートは手動のMiningも含めたノード全体の値
+func (m *Miner) Status() *MinerStatus {
+	bc := m.blockchain
+	status := &MinerStatus{
+		Running:  m.Running(),
+		Interval: m.interval,
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	status.BlocksMined = bc.blocksMined
+	status.LastBlockTime = bc.lastMinedAt
+	if bc.hashingTime > 0 {
+		status.HashRate = float64(bc.hashes) / bc.hashingTime.Seconds()
+	}
+	return status
+}
+
+type MinerStatus struct {
+	Running       bool
+	Interval      time.Duration
+	BlocksMined   int
+	LastBlockTime time.Time
+	// 1秒あたりに試したnonceの数
+	HashRate float64
+}
+
+func (s *MinerStatus) MarshalJSON() ([]byte, error) {
+	// まだ1つもマイニングしていない場合はnullにする
+	var lastBlockTime *string
+	if !s.LastBlockTime.IsZero() {
+		t := s.LastBlockTime.Format(time.RFC3339)
+		lastBlockTime = &t
+	}
+	return json.Marshal(struct {
+		Running       bool    `json:"running"`
+		IntervalSec   float64 `json:"interval_sec"`
+		BlocksMined   int     `json:"blocks_mined"`
+		LastBlockTime *string `json:"last_block_time"`
+		HashRate      float64 `json:"hash_rate"`
+	}{
+		Running:       s.Running,
+		IntervalSec:   s.Interval.Seconds(),
+		BlocksMined:   s.BlocksMined,
+		LastBlockTime: lastBlockTime,
+		HashRate:      s.HashRate,
+	})
+}
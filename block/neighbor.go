@@ -0,0 +1,172 @@
+package block
+
+import (
+	"blockchain-study/utils"
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// 近隣ノードを探すポートの範囲
+	BLOCKCHAIN_PORT_RANGE_START = 5000
+	BLOCKCHAIN_PORT_RANGE_END   = 5004
+	// 近隣ノードを探すIPの範囲(自分のIPの最後のオクテットに足す値)
+	// 127.0.0.0/8はすべて自分自身に届くため、デフォルトでは同じIPのみを走査する
+	NEIGHBOR_IP_RANGE_START = 0
+	NEIGHBOR_IP_RANGE_END   = 0
+	// 近隣ノードを探し直す間隔
+	BLOCKCHAIN_NEIGHBOR_SYNC_TIME_SEC = 20
+	// 近隣ノードへのリクエストのタイムアウト
+	NEIGHBOR_REQUEST_TIMEOUT = 5 * time.Second
+	// 近隣ノードから受け取るチェーンのJsonの最大サイズ(byte)
+	NEIGHBOR_MAX_CHAIN_SIZE = 256 << 20
+)
+
+var neighborClient = &http.Client{Timeout: NEIGHBOR_REQUEST_TIMEOUT}
+
+// 近隣ノードを探すときの自分のhostと、必ず確認するseedノード("host:port")を設定する
+func (bc *Blockchain) SetNetwork(host string, seeds []string) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.host = host
+	bc.seeds = seeds
+}
+
+// 自分のアドレス("host:port")
+func (bc *Blockchain) Address() string {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	return net.JoinHostPort(bc.host, strconv.Itoa(int(bc.port)))
+}
+
+// 現在の近隣ノードのコピーを返す
+func (bc *Blockchain) Neighbors() []string {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	neighbors := make([]string, len(bc.neighbors))
+	copy(neighbors, bc.neighbors)
+	return neighbors
+}
+
+// seedノードとローカルのhost/portの範囲を走査して、接続できるノードを近隣ノードにする
+// 走査は接続のタイムアウトを待つことがあるので、muxNeighborsは近隣ノードを入れ替える時だけロックする
+func (bc *Blockchain) SetNeighbors() {
+	self := bc.Address()
+	bc.muxNeighbors.Lock()
+	host := bc.host
+	seeds := bc.seeds
+	bc.muxNeighbors.Unlock()
+
+	neighbors := utils.FindNeighbors(
+		host, bc.port,
+		NEIGHBOR_IP_RANGE_START, NEIGHBOR_IP_RANGE_END,
+		BLOCKCHAIN_PORT_RANGE_START, BLOCKCHAIN_PORT_RANGE_END)
+
+	found := make(map[string]bool)
+	for _, n := range neighbors {
+		found[n] = true
+	}
+	for _, seed := range seeds {
+		seedHost, portStr, err := net.SplitHostPort(seed)
+		if err != nil {
+			log.Printf("ERROR: invalid seed %q: %v", seed, err)
+			continue
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			log.Printf("ERROR: invalid seed %q: %v", seed, err)
+			continue
+		}
+		if seed == self || found[seed] {
+			continue
+		}
+		if utils.IsFoundHost(seedHost, uint16(port)) {
+			neighbors = append(neighbors, seed)
+			found[seed] = true
+		}
+	}
+
+	bc.muxNeighbors.Lock()
+	bc.neighbors = neighbors
+	bc.muxNeighbors.Unlock()
+	log.Printf("action=set_neighbors, neighbors=%v", neighbors)
+}
+
+func (bc *Blockchain) SyncNeighbors() {
+	bc.SetNeighbors()
+}
+
+// 近隣ノードの探索を定期的に実行する
+func (bc *Blockchain) StartSyncNeighbors() {
+	bc.SyncNeighbors()
+	_ = time.AfterFunc(time.Second*BLOCKCHAIN_NEIGHBOR_SYNC_TIME_SEC, bc.StartSyncNeighbors)
+}
+
+// 近隣ノードへリクエストを送る。レスポンスの中身は使わないのでステータスだけログに出す
+func (bc *Blockchain) sendToNeighbors(method string, path string, body []byte) {
+	for _, n := range bc.Neighbors() {
+		endpoint := fmt.Sprintf("http://%s%s", n, path)
+		req, err := http.NewRequest(method, endpoint, bytes.NewBuffer(body))
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := neighborClient.Do(req)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			continue
+		}
+		resp.Body.Close()
+		log.Printf("action=broadcast, method=%s, endpoint=%s, status=%d", method, endpoint, resp.StatusCode)
+	}
+}
+
+// 受け付けたTransactionを近隣ノードへ転送する
+// 受け取った側はPUTとして処理し、さらに転送はしない
+func (bc *Blockchain) broadcastTransaction(sender string, recipient string, value utils.Amount, fee utils.Amount,
+	nonce uint64, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) {
+	publicKeyStr := publicKeyString(senderPublicKey)
+	signatureStr := s.String()
+	bt := &TransactionRequest{
+		SenderBlockchainAddress:    &sender,
+		RecipientBlockchainAddress: &recipient,
+		SenderPublicKey:            &publicKeyStr,
+		Value:                      &value,
+		Fee:                        &fee,
+		Nonce:                      &nonce,
+		Signature:                  &signatureStr,
+	}
+	m, _ := json.Marshal(bt)
+	bc.sendToNeighbors(http.MethodPut, "/transactions", m)
+}
+
+// 受け付けた入力と出力を持つTransactionを近隣ノードへ転送する
+func (bc *Blockchain) broadcastUTXOTransaction(t *Transaction) {
+	bt := &TransactionRequest{
+		Fee:     &t.fee,
+		Inputs:  t.inputs,
+		Outputs: t.outputs,
+	}
+	m, _ := json.Marshal(bt)
+	bc.sendToNeighbors(http.MethodPut, "/transactions", m)
+}
+
+// マイニングしたBlockを近隣ノードへ送る
+func (bc *Blockchain) broadcastBlock(b *Block) {
+	m, _ := json.Marshal(b)
+	bc.sendToNeighbors(http.MethodPut, "/blocks", m)
+}
+
+// ネットワーク関連の定期処理を開始し、近隣ノードのチェーンに追いつく
+func (bc *Blockchain) Run() {
+	bc.StartSyncNeighbors()
+	bc.ResolveConflicts()
+}
@@ -0,0 +1,45 @@
+package block
+
+import (
+	"sync"
+)
+
+// 送金した人ごとに、チェーンで使われたnonce
+// Blockを繋げるたびに追加し、チェーンが置き換わった時は作り直す
+type NonceIndex struct {
+	mux  sync.Mutex
+	used map[string]map[uint64]bool
+}
+
+func NewNonceIndex() *NonceIndex {
+	return &NonceIndex{used: make(map[string]map[uint64]bool)}
+}
+
+// 引数の人のnonceがチェーンで使われているか
+func (ni *NonceIndex) IsUsed(blockchainAddress string, nonce uint64) bool {
+	ni.mux.Lock()
+	defer ni.mux.Unlock()
+	return ni.used[blockchainAddress][nonce]
+}
+
+// 次の高さのBlockに含まれるnonceを加える
+func (ni *NonceIndex) push(b *Block) {
+	ni.mux.Lock()
+	defer ni.mux.Unlock()
+	for _, t := range b.transactions {
+		if t.IsUTXO() || t.senderBlockchainAddress == MINING_SENDER {
+			continue
+		}
+		if ni.used[t.senderBlockchainAddress] == nil {
+			ni.used[t.senderBlockchainAddress] = make(map[uint64]bool)
+		}
+		ni.used[t.senderBlockchainAddress][t.nonce] = true
+	}
+}
+
+// 中身をusedで置き換える。チェーンを置き換えた時に作り直したものを入れる
+func (ni *NonceIndex) reset(used map[string]map[uint64]bool) {
+	ni.mux.Lock()
+	defer ni.mux.Unlock()
+	ni.used = used
+}
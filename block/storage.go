@@ -0,0 +1,313 @@
+package block
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// ブロック本体を1行1ブロックのJSONで追記していくファイル
+	BLOCK_LOG_FILE = "blocks.log"
+	// 各ブロックのblocks.log内での位置(offset 8byte + length 4byte)を並べたファイル
+	BLOCK_INDEX_FILE = "blocks.idx"
+	// 未承認のtransactionPoolを保存するファイル
+	TRANSACTION_POOL_FILE = "transaction_pool.json"
+	// LEDGER_UTXOの場合の未使用の出力の集合を保存するファイル
+	UTXO_SET_FILE = "utxo.json"
+
+	indexEntrySize = 12
+)
+
+// ブロックチェーンの内容をディスクに保存するためのストレージ
+type Storage struct {
+	dir string
+	mux sync.Mutex
+}
+
+// dirを保存先とするストレージを作成。ディレクトリがなければ作る
+func NewStorage(dir string) (*Storage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Storage{dir: dir}, nil
+}
+
+func (s *Storage) Dir() string {
+	return s.dir
+}
+
+func (s *Storage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// ブロックをblocks.logの末尾に追記し、その位置をblocks.idxに記録する
+func (s *Storage) AppendBlock(b *Block) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	m, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	m = append(m, '\n')
+
+	logFile, err := os.OpenFile(s.path(BLOCK_LOG_FILE), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	info, err := logFile.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+
+	if _, err := logFile.Write(m); err != nil {
+		return err
+	}
+	if err := logFile.Sync(); err != nil {
+		return err
+	}
+
+	// blocks.logへの書き込みが完了してからindexを追記する
+	idxFile, err := os.OpenFile(s.path(BLOCK_INDEX_FILE), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer idxFile.Close()
+
+	entry := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint64(entry[:8], uint64(offset))
+	binary.BigEndian.PutUint32(entry[8:], uint32(len(m)))
+	if _, err := idxFile.Write(entry); err != nil {
+		return err
+	}
+	return idxFile.Sync()
+}
+
+// 保存されているチェーンをblocksで丸ごと置き換える
+// blocks.logとblocks.idxの一時ファイルを両方書き終えてから、それぞれrenameで差し替える
+// 差し替えの途中で止まった場合は、次のLoadBlocksでfinishReplaceが続きを行う
+func (s *Storage) ReplaceBlocks(blocks []*Block) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var logBuf []byte
+	idxBuf := make([]byte, 0, len(blocks)*indexEntrySize)
+	for _, b := range blocks {
+		m, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		m = append(m, '\n')
+
+		entry := make([]byte, indexEntrySize)
+		binary.BigEndian.PutUint64(entry[:8], uint64(len(logBuf)))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(m)))
+		idxBuf = append(idxBuf, entry...)
+		logBuf = append(logBuf, m...)
+	}
+
+	if err := writeFileSync(s.path(BLOCK_LOG_FILE)+".tmp", logBuf); err != nil {
+		return err
+	}
+	// blocks.idxの一時ファイルができた時点で差し替えが確定する。書きかけが残らないようこれもrenameで作る
+	if err := writeFileAtomic(s.path(BLOCK_INDEX_FILE)+".tmp", idxBuf); err != nil {
+		return err
+	}
+	return s.finishReplace()
+}
+
+// ReplaceBlocksの差し替えを完了させる
+// blocks.idxの一時ファイルがあればblocks.log, blocks.idxの順に置き換え、なければ書きかけのblocks.logの一時ファイルを消す
+func (s *Storage) finishReplace() error {
+	logTmp := s.path(BLOCK_LOG_FILE) + ".tmp"
+	idxTmp := s.path(BLOCK_INDEX_FILE) + ".tmp"
+	if _, err := os.Stat(idxTmp); errors.Is(err, os.ErrNotExist) {
+		if err := os.Remove(logTmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// blocks.logの置き換えまで済んでいた場合は一時ファイルがない
+	if err := os.Rename(logTmp, s.path(BLOCK_LOG_FILE)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Rename(idxTmp, s.path(BLOCK_INDEX_FILE))
+}
+
+// 保存されているブロックをindexの順に読み込む。
+// 途中で止まったReplaceBlocksがあれば先に完了させる。書き込み途中で止まった分(indexにない末尾のデータ、半端なindex)は切り捨てる
+func (s *Storage) LoadBlocks() ([]*Block, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err := s.finishReplace(); err != nil {
+		return nil, err
+	}
+
+	idx, err := os.ReadFile(s.path(BLOCK_INDEX_FILE))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logFile, err := os.Open(s.path(BLOCK_LOG_FILE))
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	info, err := logFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	logSize := info.Size()
+
+	blocks := make([]*Block, 0, len(idx)/indexEntrySize)
+	var end int64 = 0
+	for i := 0; i+indexEntrySize <= len(idx); i += indexEntrySize {
+		offset := int64(binary.BigEndian.Uint64(idx[i : i+8]))
+		length := int64(binary.BigEndian.Uint32(idx[i+8 : i+indexEntrySize]))
+		if offset != end || offset+length > logSize {
+			break
+		}
+
+		buf := make([]byte, length)
+		if _, err := logFile.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		b := new(Block)
+		if err := json.Unmarshal(buf, b); err != nil {
+			return nil, fmt.Errorf("block %d: %v", len(blocks), err)
+		}
+		blocks = append(blocks, b)
+		end = offset + length
+	}
+
+	if err := s.truncate(len(blocks), end); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// 読み込めたブロック数と最後のブロックの終端に合わせてファイルを切り詰める
+func (s *Storage) truncate(count int, end int64) error {
+	if err := truncateIfLonger(s.path(BLOCK_INDEX_FILE), int64(count*indexEntrySize)); err != nil {
+		return err
+	}
+	return truncateIfLonger(s.path(BLOCK_LOG_FILE), end)
+}
+
+func truncateIfLonger(name string, size int64) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.Size() > size {
+		return os.Truncate(name, size)
+	}
+	return nil
+}
+
+// transactionPoolの中身を丸ごと保存する。一時ファイルに書いてからrenameで置き換える
+func (s *Storage) SaveTransactionPool(transactions []*Transaction) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if transactions == nil {
+		transactions = []*Transaction{}
+	}
+	m, err := json.Marshal(transactions)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(TRANSACTION_POOL_FILE), m)
+}
+
+// 保存されているtransactionPoolを読み込む
+func (s *Storage) LoadTransactionPool() ([]*Transaction, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	m, err := os.ReadFile(s.path(TRANSACTION_POOL_FILE))
+	if errors.Is(err, os.ErrNotExist) {
+		return []*Transaction{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var transactions []*Transaction
+	if err := json.Unmarshal(m, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// UTXOの集合を丸ごと保存する。一時ファイルに書いてからrenameで置き換える
+func (s *Storage) SaveUTXOSet(utxos *UTXOSet) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	m, err := json.Marshal(utxos)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(UTXO_SET_FILE), m)
+}
+
+// 保存されているUTXOの集合を読み込む。保存されていなければnilを返す
+func (s *Storage) LoadUTXOSet() (*UTXOSet, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	m, err := os.ReadFile(s.path(UTXO_SET_FILE))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	utxos := NewUTXOSet()
+	if err := json.Unmarshal(m, utxos); err != nil {
+		return nil, err
+	}
+	return utxos, nil
+}
+
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// ファイルに書き込み、ディスクに反映されるまで待つ
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
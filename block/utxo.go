@@ -0,0 +1,407 @@
+package block
+
+import (
+	"blockchain-study/utils"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// 残高の持ち方。accountはアドレスごとの残高とnonce、utxoは未使用の出力の集合で管理する
+const (
+	LEDGER_ACCOUNT = "account"
+	LEDGER_UTXO    = "utxo"
+)
+
+// 以前のTransactionの何番目の出力かを指す参照
+// マイニング報酬のTransactionは受け取った人への出力を0番目に1つだけ持つとみなす
+type OutPoint struct {
+	TxHash [32]byte
+	Index  uint32
+}
+
+func (op OutPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TxHash string `json:"tx_hash"`
+		Index  uint32 `json:"index"`
+	}{
+		TxHash: fmt.Sprintf("%x", op.TxHash),
+		Index:  op.Index,
+	})
+}
+
+func (op *OutPoint) UnmarshalJSON(data []byte) error {
+	v := struct {
+		TxHash *string `json:"tx_hash"`
+		Index  *uint32 `json:"index"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.TxHash == nil || v.Index == nil {
+		return fmt.Errorf("missing tx_hash or index")
+	}
+	if err := decodeHash(*v.TxHash, &op.TxHash); err != nil {
+		return fmt.Errorf("tx_hash: %v", err)
+	}
+	op.Index = *v.Index
+	return nil
+}
+
+// UTXOのTransactionの入力。使う出力と、その出力を持つ人の公開鍵と署名
+type TxInput struct {
+	previousOutput  OutPoint
+	senderPublicKey *ecdsa.PublicKey
+	signature       *utils.Signature
+}
+
+func NewTxInput(previousOutput OutPoint, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) *TxInput {
+	return &TxInput{
+		previousOutput:  previousOutput,
+		senderPublicKey: senderPublicKey,
+		signature:       s,
+	}
+}
+
+func (in *TxInput) PreviousOutput() OutPoint {
+	return in.previousOutput
+}
+
+func (in *TxInput) MarshalJSON() ([]byte, error) {
+	var publicKey, signature string
+	if in.senderPublicKey != nil {
+		publicKey = publicKeyString(in.senderPublicKey)
+	}
+	if in.signature != nil {
+		signature = in.signature.String()
+	}
+	return json.Marshal(struct {
+		TxHash    string `json:"tx_hash"`
+		Index     uint32 `json:"index"`
+		PublicKey string `json:"sender_public_key"`
+		Signature string `json:"signature"`
+	}{
+		TxHash:    fmt.Sprintf("%x", in.previousOutput.TxHash),
+		Index:     in.previousOutput.Index,
+		PublicKey: publicKey,
+		Signature: signature,
+	})
+}
+
+func (in *TxInput) UnmarshalJSON(data []byte) error {
+	v := struct {
+		PublicKey string `json:"sender_public_key"`
+		Signature string `json:"signature"`
+	}{}
+	if err := json.Unmarshal(data, &in.previousOutput); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	var err error
+	if in.senderPublicKey, err = utils.PublicKeyFromString(v.PublicKey); err != nil {
+		return err
+	}
+	if in.signature, err = utils.SignatureFromString(v.Signature); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UTXOのTransactionの出力。誰がいくら受け取るか
+type TxOutput struct {
+	blockchainAddress string
+	value             utils.Amount
+}
+
+func NewTxOutput(blockchainAddress string, value utils.Amount) *TxOutput {
+	return &TxOutput{blockchainAddress: blockchainAddress, value: value}
+}
+
+func (out *TxOutput) BlockchainAddress() string {
+	return out.blockchainAddress
+}
+
+func (out *TxOutput) Value() utils.Amount {
+	return out.value
+}
+
+func (out *TxOutput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		BlockchainAddress string       `json:"blockchain_address"`
+		Value             utils.Amount `json:"value"`
+	}{
+		BlockchainAddress: out.blockchainAddress,
+		Value:             out.value,
+	})
+}
+
+func (out *TxOutput) UnmarshalJSON(data []byte) error {
+	v := struct {
+		BlockchainAddress *string       `json:"blockchain_address"`
+		Value             *utils.Amount `json:"value"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.BlockchainAddress == nil || v.Value == nil {
+		return fmt.Errorf("missing blockchain_address or value")
+	}
+	out.blockchainAddress = *v.BlockchainAddress
+	out.value = *v.Value
+	return nil
+}
+
+// 入力と出力を持つTransactionを作る。入力の合計は出力の合計とfeeの和に等しくなければならない
+// 署名はSignedPayloadのハッシュに対して、入力ごとにその出力を持つ人の鍵で行う
+func NewUTXOTransaction(inputs []*TxInput, outputs []*TxOutput, fee utils.Amount) *Transaction {
+	return &Transaction{inputs: inputs, outputs: outputs, fee: fee}
+}
+
+// 入力と出力を持つTransactionかどうか
+func (t *Transaction) IsUTXO() bool {
+	return len(t.inputs) > 0 || len(t.outputs) > 0
+}
+
+func (t *Transaction) Inputs() []*TxInput {
+	return t.inputs
+}
+
+func (t *Transaction) Outputs() []*TxOutput {
+	return t.outputs
+}
+
+// UTXOの集合に加える出力。マイニング報酬は受け取った人への出力が1つ、送金額で表すTransactionは出力なし
+func (t *Transaction) unspentOutputs() []*TxOutput {
+	if t.senderBlockchainAddress == MINING_SENDER {
+		return []*TxOutput{NewTxOutput(t.recipientBlockchainAddress, t.value)}
+	}
+	return t.outputs
+}
+
+// 未使用の出力と、それを指す参照の組
+type UnspentOutput struct {
+	OutPoint OutPoint
+	Output   *TxOutput
+}
+
+func (uo *UnspentOutput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TxHash            string       `json:"tx_hash"`
+		Index             uint32       `json:"index"`
+		BlockchainAddress string       `json:"blockchain_address"`
+		Value             utils.Amount `json:"value"`
+	}{
+		TxHash:            fmt.Sprintf("%x", uo.OutPoint.TxHash),
+		Index:             uo.OutPoint.Index,
+		BlockchainAddress: uo.Output.blockchainAddress,
+		Value:             uo.Output.value,
+	})
+}
+
+func (uo *UnspentOutput) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &uo.OutPoint); err != nil {
+		return err
+	}
+	uo.Output = new(TxOutput)
+	return json.Unmarshal(data, uo.Output)
+}
+
+// チェーンの最後のBlockまでで使われていない出力の集合
+// tipはこの集合がどのBlockまでを反映しているか。保存したものを読み込む時にチェーンと比べる
+type UTXOSet struct {
+	mux     sync.Mutex
+	tip     [32]byte
+	outputs map[OutPoint]*TxOutput
+}
+
+func NewUTXOSet() *UTXOSet {
+	return &UTXOSet{outputs: make(map[OutPoint]*TxOutput)}
+}
+
+func (us *UTXOSet) Tip() [32]byte {
+	us.mux.Lock()
+	defer us.mux.Unlock()
+	return us.tip
+}
+
+// 参照先が未使用ならその出力を返す。使用済みか存在しなければnil
+func (us *UTXOSet) Get(op OutPoint) *TxOutput {
+	us.mux.Lock()
+	defer us.mux.Unlock()
+	return us.outputs[op]
+}
+
+// 引数の人が持つ未使用の出力を、Transactionのハッシュと番号の順に返す
+func (us *UTXOSet) Unspent(blockchainAddress string) []*UnspentOutput {
+	us.mux.Lock()
+	defer us.mux.Unlock()
+	unspent := make([]*UnspentOutput, 0)
+	for op, out := range us.outputs {
+		if out.blockchainAddress == blockchainAddress {
+			unspent = append(unspent, &UnspentOutput{OutPoint: op, Output: out})
+		}
+	}
+	sortUnspentOutputs(unspent)
+	return unspent
+}
+
+// 引数の人が持つ未使用の出力の合計
+func (us *UTXOSet) Balance(blockchainAddress string) utils.Amount {
+	us.mux.Lock()
+	defer us.mux.Unlock()
+	var balance utils.Amount = 0
+	for _, out := range us.outputs {
+		if out.blockchainAddress == blockchainAddress {
+			balance += out.value
+		}
+	}
+	return balance
+}
+
+// Blockの入力を使用済みにして、出力を加える。bはチェーンの最後に繋がったBlockであること
+func (us *UTXOSet) apply(b *Block) {
+	us.mux.Lock()
+	defer us.mux.Unlock()
+	for _, t := range b.transactions {
+		applyOutputs(us.outputs, t)
+	}
+	us.tip = b.Hash()
+}
+
+// 集合の中身をoutputsで置き換える。チェーンを置き換えた時に作り直したものを入れる
+func (us *UTXOSet) reset(outputs map[OutPoint]*TxOutput, tip [32]byte) {
+	us.mux.Lock()
+	defer us.mux.Unlock()
+	us.outputs = outputs
+	us.tip = tip
+}
+
+// 検証用に集合の中身をコピーして返す
+func (us *UTXOSet) snapshot() map[OutPoint]*TxOutput {
+	us.mux.Lock()
+	defer us.mux.Unlock()
+	outputs := make(map[OutPoint]*TxOutput, len(us.outputs))
+	for op, out := range us.outputs {
+		outputs[op] = out
+	}
+	return outputs
+}
+
+func (us *UTXOSet) MarshalJSON() ([]byte, error) {
+	us.mux.Lock()
+	unspent := make([]*UnspentOutput, 0, len(us.outputs))
+	for op, out := range us.outputs {
+		unspent = append(unspent, &UnspentOutput{OutPoint: op, Output: out})
+	}
+	tip := us.tip
+	us.mux.Unlock()
+
+	sortUnspentOutputs(unspent)
+	return json.Marshal(struct {
+		Tip     string           `json:"tip"`
+		Outputs []*UnspentOutput `json:"outputs"`
+	}{
+		Tip:     fmt.Sprintf("%x", tip),
+		Outputs: unspent,
+	})
+}
+
+func (us *UTXOSet) UnmarshalJSON(data []byte) error {
+	v := struct {
+		Tip     string           `json:"tip"`
+		Outputs []*UnspentOutput `json:"outputs"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	var tip [32]byte
+	if err := decodeHash(v.Tip, &tip); err != nil {
+		return fmt.Errorf("tip: %v", err)
+	}
+	outputs := make(map[OutPoint]*TxOutput, len(v.Outputs))
+	for _, uo := range v.Outputs {
+		outputs[uo.OutPoint] = uo.Output
+	}
+	us.reset(outputs, tip)
+	return nil
+}
+
+// tの入力が指す出力を使用済みにし、tの出力を未使用として加える
+func applyOutputs(outputs map[OutPoint]*TxOutput, t *Transaction) {
+	for _, in := range t.inputs {
+		delete(outputs, in.previousOutput)
+	}
+	hash := t.Hash()
+	for i, out := range t.unspentOutputs() {
+		outputs[OutPoint{TxHash: hash, Index: uint32(i)}] = out
+	}
+}
+
+func sortUnspentOutputs(unspent []*UnspentOutput) {
+	sort.Slice(unspent, func(i, j int) bool {
+		a, b := unspent[i].OutPoint, unspent[j].OutPoint
+		if a.TxHash != b.TxHash {
+			return fmt.Sprintf("%x", a.TxHash) < fmt.Sprintf("%x", b.TxHash)
+		}
+		return a.Index < b.Index
+	})
+}
+
+// UTXOのTransactionを未使用の出力の集合unspentに対して検証する
+// 入力はunspentにあり同じものを二重に使っていないこと、公開鍵が出力を持つ人のもので署名が正しいこと、
+// 出力は正の額で、入力の合計が出力の合計とfeeの和に等しいこと
+// 合計はint64があふれて小さな値になると入力より少なく見えてしまうので、MAX_AMOUNTを超えたら拒否する
+func (bc *Blockchain) validateUTXOTransaction(t *Transaction, unspent map[OutPoint]*TxOutput) error {
+	if len(t.inputs) == 0 || len(t.outputs) == 0 {
+		return fmt.Errorf("%w: inputs and outputs are required", ErrInvalidValue)
+	}
+
+	h := sha256.Sum256(t.SignedPayload())
+	var total utils.Amount = 0
+	var err error
+	used := make(map[OutPoint]bool)
+	for i, in := range t.inputs {
+		out, ok := unspent[in.previousOutput]
+		if !ok || used[in.previousOutput] {
+			return fmt.Errorf("input %d: %w", i, ErrDoubleSpend)
+		}
+		used[in.previousOutput] = true
+		// 出力を使えるのは、そのアドレスの鍵を持つ人だけ
+		if !isAddressOf(out.blockchainAddress, in.senderPublicKey) {
+			return fmt.Errorf("input %d: %w", i, ErrAddressMismatch)
+		}
+		if in.senderPublicKey == nil || in.signature == nil ||
+			!ecdsa.Verify(in.senderPublicKey, h[:], in.signature.R, in.signature.S) {
+			return fmt.Errorf("input %d: %w", i, ErrInvalidSignature)
+		}
+		if total, err = utils.AddAmounts(total, out.value); err != nil {
+			return fmt.Errorf("input %d: %w: %v", i, ErrInvalidValue, err)
+		}
+	}
+
+	var spent utils.Amount = 0
+	for i, out := range t.outputs {
+		if out.value <= 0 {
+			return fmt.Errorf("output %d: %w", i, ErrInvalidValue)
+		}
+		if spent, err = utils.AddAmounts(spent, out.value); err != nil {
+			return fmt.Errorf("output %d: %w: %v", i, ErrInvalidValue, err)
+		}
+	}
+	required, err := utils.AddAmounts(spent, t.fee)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFee, err)
+	}
+	if total < required {
+		return fmt.Errorf("%w: inputs %v, outputs %v, fee %v", ErrInsufficientBalance, total, spent, t.fee)
+	}
+	if total > required {
+		return fmt.Errorf("%w: inputs %v exceed outputs %v and fee %v", ErrInvalidFee, total, spent, t.fee)
+	}
+	return nil
+}
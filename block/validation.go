@@ -0,0 +1,227 @@
+package block
+
+import (
+	"blockchain-study/utils"
+	"blockchain-study/wallet"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 他のノードから受け取ったBlockのtimestampが、現在時刻よりどれだけ未来まで許されるか
+const BLOCK_MAX_FUTURE_SEC = 120
+
+var (
+	ErrInvalidGenesis       = errors.New("invalid genesis block")
+	ErrInvalidPreviousHash  = errors.New("previous hash does not match the last block")
+	ErrInvalidProof         = errors.New("invalid proof of work")
+	ErrInvalidDifficulty    = errors.New("difficulty does not match the retarget rule")
+	ErrInvalidMerkleRoot    = errors.New("merkle root does not match the transactions")
+	ErrInvalidTimestamp     = errors.New("block timestamp out of range")
+	ErrInvalidSignature     = errors.New("invalid transaction signature")
+	ErrInsufficientBalance  = errors.New("insufficient balance")
+	ErrDuplicateTransaction = errors.New("duplicate transaction")
+	ErrInvalidReward        = errors.New("invalid mining reward")
+	ErrInvalidValue         = errors.New("invalid transaction value")
+	ErrInvalidFee           = errors.New("invalid transaction fee")
+	ErrBlockTooLarge        = errors.New("block exceeds the maximum size")
+	ErrDoubleSpend          = errors.New("input is already spent or does not exist")
+	ErrLedgerMismatch       = errors.New("transaction type does not match the ledger")
+	ErrMissingFields        = errors.New("missing field(s)")
+	ErrAddressMismatch      = errors.New("public key does not match the sender address")
+)
+
+// チェーンを先頭からたどった時点の状態
+// 各アドレスの残高と、送金した人ごとに使われたnonce、未使用の出力
+type chainState struct {
+	balances   map[string]utils.Amount
+	usedNonces map[string]map[uint64]bool
+	unspent    map[OutPoint]*TxOutput
+
+	// 自分のチェーンの最後のBlockに続けて検証する場合の、そこまでの残高とnonce
+	// この場合balancesとusedNoncesはその後に反映した分だけを持つ。先頭から検証する場合はnil
+	confirmedBalances *BalanceIndex
+	confirmedNonces   *NonceIndex
+}
+
+func newChainState() *chainState {
+	return &chainState{
+		balances:   make(map[string]utils.Amount),
+		usedNonces: make(map[string]map[uint64]bool),
+		unspent:    make(map[OutPoint]*TxOutput),
+	}
+}
+
+func (cs *chainState) balance(blockchainAddress string) utils.Amount {
+	balance := cs.balances[blockchainAddress]
+	if cs.confirmedBalances != nil {
+		balance += cs.confirmedBalances.Balance(blockchainAddress)
+	}
+	return balance
+}
+
+func (cs *chainState) isNonceUsed(blockchainAddress string, nonce uint64) bool {
+	if cs.usedNonces[blockchainAddress][nonce] {
+		return true
+	}
+	return cs.confirmedNonces != nil && cs.confirmedNonces.IsUsed(blockchainAddress, nonce)
+}
+
+// 検証済みのBlockの内容をstateに反映する
+func (cs *chainState) apply(b *Block) {
+	for _, t := range b.transactions {
+		cs.applyTransaction(t)
+	}
+}
+
+func (cs *chainState) applyTransaction(t *Transaction) {
+	if t.IsUTXO() {
+		for _, in := range t.inputs {
+			if out, ok := cs.unspent[in.previousOutput]; ok {
+				cs.balances[out.blockchainAddress] -= out.value
+			}
+		}
+		for _, out := range t.outputs {
+			cs.balances[out.blockchainAddress] += out.value
+		}
+		applyOutputs(cs.unspent, t)
+		return
+	}
+
+	applyOutputs(cs.unspent, t)
+	if t.senderBlockchainAddress != MINING_SENDER {
+		cs.balances[t.senderBlockchainAddress] -= t.value + t.fee
+		if cs.usedNonces[t.senderBlockchainAddress] == nil {
+			cs.usedNonces[t.senderBlockchainAddress] = make(map[uint64]bool)
+		}
+		cs.usedNonces[t.senderBlockchainAddress][t.nonce] = true
+	}
+	cs.balances[t.recipientBlockchainAddress] += t.value
+}
+
+// チェーンが正しいかを検証する
+func (bc *Blockchain) ValidChain(chain []*Block) bool {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.validateChain(chain) == nil
+}
+
+// genesis以降のBlockについて、ハッシュの繋がり、Proof of Work、マークルルート、timestamp、署名と残高のルールを確認する
+// genesisはTransactionを持たず難易度がMINING_DIFFICULTYで、timestampが未来でないこと
+// 自分のチェーンがある場合は、genesisがそれと同じでなければ無関係のチェーンとして拒否する
+// bc.muxをロックした状態で呼ぶこと
+func (bc *Blockchain) validateChain(chain []*Block) error {
+	if len(chain) == 0 || len(chain[0].transactions) != 0 {
+		return ErrInvalidGenesis
+	}
+	// genesisの難易度は以降の難易度の調整の起点になるので、低くして仕事量を偽れないようにする
+	genesis := &chain[0].header
+	if genesis.difficulty != MINING_DIFFICULTY {
+		return fmt.Errorf("%w: difficulty %d", ErrInvalidGenesis, genesis.difficulty)
+	}
+	if genesis.timestamp > time.Now().Add(BLOCK_MAX_FUTURE_SEC*time.Second).UnixNano() {
+		return fmt.Errorf("%w: timestamp %d", ErrInvalidGenesis, genesis.timestamp)
+	}
+	if len(bc.chain) > 0 && chain[0].Hash() != bc.chain[0].Hash() {
+		return fmt.Errorf("%w: hash %x", ErrInvalidGenesis, chain[0].Hash())
+	}
+
+	state := newChainState()
+	for currentIndex := 1; currentIndex < len(chain); currentIndex++ {
+		if err := bc.validateBlock(chain[:currentIndex], chain[currentIndex], state); err != nil {
+			return fmt.Errorf("block %d: %w", currentIndex, err)
+		}
+	}
+	return nil
+}
+
+// chainの次に繋がるBlockとしてbを検証し、問題なければstateに反映する
+func (bc *Blockchain) validateBlock(chain []*Block, b *Block, state *chainState) error {
+	preBlock := chain[len(chain)-1]
+	if b.header.previousHash != preBlock.Hash() {
+		return ErrInvalidPreviousHash
+	}
+	if b.header.difficulty != NextDifficulty(chain) {
+		return ErrInvalidDifficulty
+	}
+	if !bc.ValidProof(&b.header) {
+		return ErrInvalidProof
+	}
+	if b.header.merkleRoot != TransactionsMerkleRoot(b.transactions) {
+		return ErrInvalidMerkleRoot
+	}
+	if b.Size() > MAX_BLOCK_SIZE {
+		return ErrBlockTooLarge
+	}
+	maxTimestamp := time.Now().Add(BLOCK_MAX_FUTURE_SEC * time.Second).UnixNano()
+	if b.header.timestamp <= preBlock.header.timestamp || b.header.timestamp > maxTimestamp {
+		return ErrInvalidTimestamp
+	}
+	return bc.validateTransactions(b.transactions, state)
+}
+
+// 1つのBlockに含まれるTransactionを検証し、stateに反映する
+// マイニング報酬は1Blockに1つまでで額はMINING_REWARDとBlock内の手数料の合計、
+// LEDGER_ACCOUNTでは公開鍵が送金した人のアドレスのもので署名が正しく、送金額と手数料が残高の範囲内で、
+// 同じ人の同じnonceのTransactionがチェーンやBlockの中にすでにないこと
+// LEDGER_UTXOでは入力と出力を持ち、validateUTXOTransactionを満たすこと
+func (bc *Blockchain) validateTransactions(transactions []*Transaction, state *chainState) error {
+	// 額の合計はint64があふれないよう、MAX_AMOUNTを超えないことを確かめながら足す
+	var fees utils.Amount = 0
+	for i, t := range transactions {
+		if t.fee < 0 || (t.senderBlockchainAddress == MINING_SENDER && t.fee != 0) {
+			return fmt.Errorf("transaction %d: %w", i, ErrInvalidFee)
+		}
+		var err error
+		if fees, err = utils.AddAmounts(fees, t.fee); err != nil {
+			return fmt.Errorf("transaction %d: %w: %v", i, ErrInvalidFee, err)
+		}
+	}
+
+	rewards := 0
+	for i, t := range transactions {
+		if t.IsUTXO() {
+			if bc.ledger != LEDGER_UTXO {
+				return fmt.Errorf("transaction %d: %w", i, ErrLedgerMismatch)
+			}
+			if err := bc.validateUTXOTransaction(t, state.unspent); err != nil {
+				return fmt.Errorf("transaction %d: %w", i, err)
+			}
+		} else if t.value <= 0 {
+			return fmt.Errorf("transaction %d: %w", i, ErrInvalidValue)
+		} else if t.senderBlockchainAddress == MINING_SENDER {
+			rewards += 1
+			if rewards > 1 || t.value != MINING_REWARD+fees {
+				return fmt.Errorf("transaction %d: %w", i, ErrInvalidReward)
+			}
+		} else if bc.ledger != LEDGER_ACCOUNT {
+			return fmt.Errorf("transaction %d: %w", i, ErrLedgerMismatch)
+		} else {
+			if !isAddressOf(t.senderBlockchainAddress, t.senderPublicKey) {
+				return fmt.Errorf("transaction %d: %w", i, ErrAddressMismatch)
+			}
+			if !bc.VerifyTransactionSignature(t.senderPublicKey, t.signature, t) {
+				return fmt.Errorf("transaction %d: %w", i, ErrInvalidSignature)
+			}
+			if state.isNonceUsed(t.senderBlockchainAddress, t.nonce) {
+				return fmt.Errorf("transaction %d: %w: nonce %d already used", i, ErrDuplicateTransaction, t.nonce)
+			}
+			required, err := utils.AddAmounts(t.value, t.fee)
+			if err != nil {
+				return fmt.Errorf("transaction %d: %w: %v", i, ErrInvalidValue, err)
+			}
+			if state.balance(t.senderBlockchainAddress) < required {
+				return fmt.Errorf("transaction %d: %w", i, ErrInsufficientBalance)
+			}
+		}
+		state.applyTransaction(t)
+	}
+	return nil
+}
+
+// 公開鍵から求めたアドレスがblockchainAddressと一致するか
+// 一致しなければ、他人の鍵で署名して他人のアドレスから送金できてしまう
+func isAddressOf(blockchainAddress string, publicKey *ecdsa.PublicKey) bool {
+	return publicKey != nil && wallet.BlockchainAddressFromPublicKey(publicKey) == blockchainAddress
+}
@@ -4,36 +4,127 @@ import (
 	"blockchain-study/block"
 	"blockchain-study/utils"
 	"blockchain-study/wallet"
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// シグナルを受け取ってから処理中のリクエストを待つ時間
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
 var cache map[string]*block.Blockchain = make(map[string]*block.Blockchain)
 
+// keystoreのパスフレーズを渡す環境変数
+const KEYSTORE_PASSPHRASE_ENV = "BLOCKCHAIN_KEYSTORE_PASSPHRASE"
+
 type BlockchainServer struct {
-	port uint16
+	port     uint16
+	dataDir  string
+	host     string
+	seeds    []string
+	workers  int
+	ledger   string
+	keystore string
+	miner    *block.Miner
 }
 
-func NewBlockChainServer(port uint16) *BlockchainServer {
-	return &BlockchainServer{port}
+func NewBlockChainServer(port uint16, dataDir string, host string, seeds []string, workers int, ledger string, keystore string) *BlockchainServer {
+	return &BlockchainServer{port: port, dataDir: dataDir, host: host, seeds: seeds, workers: workers, ledger: ledger, keystore: keystore}
 }
 
 func (bsc *BlockchainServer) Port() uint16 {
 	return bsc.port
 }
 
+func (bsc *BlockchainServer) DataDir() string {
+	return bsc.dataDir
+}
+
+func (bsc *BlockchainServer) Host() string {
+	return bsc.host
+}
+
+func (bsc *BlockchainServer) Seeds() []string {
+	return bsc.seeds
+}
+
+func (bsc *BlockchainServer) Workers() int {
+	return bsc.workers
+}
+
+func (bsc *BlockchainServer) Ledger() string {
+	return bsc.ledger
+}
+
+func (bsc *BlockchainServer) Keystore() string {
+	return bsc.keystore
+}
+
+// マイニングの報酬を受け取るWalletを返す
+// keystoreが指定されていればそこから読み込み、ファイルがなければ新しく作って保存する
+// 指定されていない場合は起動するたびに新しく作る
+func (bsc *BlockchainServer) minersWallet() (*wallet.Wallet, error) {
+	if bsc.Keystore() == "" {
+		w := wallet.NewWallet()
+		log.Printf("private_key %v", w.PrivateKeyStr())
+		return w, nil
+	}
+
+	passphrase := os.Getenv(KEYSTORE_PASSPHRASE_ENV)
+	if passphrase == "" {
+		log.Printf("WARN: %s is empty, keystore is encrypted with an empty passphrase", KEYSTORE_PASSPHRASE_ENV)
+	}
+	w, err := wallet.LoadKeystore(bsc.Keystore(), passphrase)
+	if err == nil {
+		log.Printf("action=load_keystore, status=success, path=%s", bsc.Keystore())
+		return w, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("keystore %s: %w", bsc.Keystore(), err)
+	}
+
+	w = wallet.NewWallet()
+	if err := w.SaveKeystore(bsc.Keystore(), passphrase); err != nil {
+		return nil, fmt.Errorf("keystore %s: %w", bsc.Keystore(), err)
+	}
+	log.Printf("action=create_keystore, status=success, path=%s", bsc.Keystore())
+	return w, nil
+}
+
 func (bsc *BlockchainServer) GetBlockchain() *block.Blockchain {
 	bc, ok := cache["blockchain"]
 
 	// キャッシュがない場合は新たにブロックチェーンを作成
 	if !ok {
-		minersWallet := wallet.NewWallet()
-		bc = block.NewBlockChain(minersWallet.BlockchainAddress(), bsc.Port())
+		minersWallet, err := bsc.minersWallet()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// dataDirに保存済みのチェーンがあれば読み込む
+		storage, err := block.NewStorage(bsc.DataDir())
+		if err != nil {
+			log.Fatal(err)
+		}
+		bc, err = block.NewBlockChainWithStorage(minersWallet.BlockchainAddress(), bsc.Port(), bsc.Ledger(), storage)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bc.SetNetwork(bsc.Host(), bsc.Seeds())
+		bc.SetMiningWorkers(bsc.Workers())
 		cache["blockchain"] = bc
-		log.Printf("private_key %v", minersWallet.PrivateKeyStr())
 		log.Printf("public_key %v", minersWallet.PublicKeyStr())
 		log.Printf("blockchain_address %v", minersWallet.BlockchainAddress())
 	}
@@ -76,38 +167,95 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 		decoder := json.NewDecoder(req.Body)
 		var t block.TransactionRequest
 
-		// リクエストのJsonがTransactionRequestにデコードできない場合はerrに入る
+		// リクエストのJsonがTransactionRequestにデコードできない場合(入力の公開鍵や署名の形式が正しくない場合を含む)はerrに入る
 		err := decoder.Decode(&t)
 		if err != nil {
 			log.Printf("ERROR: %v", err)
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
 			return
 		}
-		if !t.Validate() {
-			log.Println("ERROR: missing field(s)")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+		if err := t.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
 			return
 		}
 
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
-
 		bc := bcs.GetBlockchain()
 
 		// wallet_serverから送られてきたJsonを元に、新しいTransactionを作成
-		isCreated := bc.CreateTransaction(*t.SenderBlockchainAddress,
-			*t.RecipientBlockchainAddress, *t.Value, publicKey, signature)
+		var transaction *block.Transaction
+		if t.IsUTXO() {
+			transaction, err = bc.CreateUTXOTransaction(t.Inputs, t.Outputs, t.FeeAmount())
+		} else {
+			var publicKey *ecdsa.PublicKey
+			var signature *utils.Signature
+			publicKey, signature, err = t.PublicKeyAndSignature()
+			if err == nil {
+				transaction, err = bc.CreateTransaction(*t.SenderBlockchainAddress,
+					*t.RecipientBlockchainAddress, *t.Value, t.FeeAmount(), *t.Nonce, publicKey, signature)
+			}
+		}
 
 		w.Header().Add("Content-Type", "application/json")
 		var m []byte
-		if !isCreated {
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			m = utils.JsonStatus("fail")
+			m = utils.JsonStatusWithReason("fail", err.Error())
 		} else {
 			w.WriteHeader(http.StatusCreated)
-			m = utils.JsonStatus("succsess")
+			// 作成したTransactionのハッシュを返し、/transactions/{hash}で状態を確認できるようにする
+			m, _ = json.Marshal(struct {
+				Message string `json:"message"`
+				Hash    string `json:"hash"`
+			}{
+				Message: "succsess",
+				Hash:    fmt.Sprintf("%x", transaction.Hash()),
+			})
+		}
+
+		io.WriteString(w, string(m))
+
+	// 近隣ノードから転送されてきたTransactionを受け取る。ここからはさらに転送しない
+	case http.MethodPut:
+		decoder := json.NewDecoder(req.Body)
+		var t block.TransactionRequest
+		err := decoder.Decode(&t)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
+			return
+		}
+		if err := t.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
+			return
 		}
 
+		bc := bcs.GetBlockchain()
+		if t.IsUTXO() {
+			_, err = bc.AddUTXOTransaction(t.Inputs, t.Outputs, t.FeeAmount())
+		} else {
+			var publicKey *ecdsa.PublicKey
+			var signature *utils.Signature
+			publicKey, signature, err = t.PublicKeyAndSignature()
+			if err == nil {
+				_, err = bc.AddTransaction(*t.SenderBlockchainAddress,
+					*t.RecipientBlockchainAddress, *t.Value, t.FeeAmount(), *t.Nonce, publicKey, signature)
+			}
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		var m []byte
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			m = utils.JsonStatusWithReason("fail", err.Error())
+		} else {
+			m = utils.JsonStatus("success")
+		}
 		io.WriteString(w, string(m))
 	default:
 		log.Println("ERROR: Invalid HTTP Method")
@@ -115,6 +263,215 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 	}
 }
 
+// ハッシュを指定してTransactionの状態(pending, confirmed, unknown)を返すAPI
+// パスは/transactions/{hash}。/transactions/{hash}/proofの場合はマークルツリーでの包含証明を返す
+func (bcs *BlockchainServer) TransactionByHash(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		hashStr := strings.TrimPrefix(req.URL.Path, "/transactions/")
+		hashStr, isProof := cutSuffix(hashStr, "/proof")
+		h, err := hex.DecodeString(hashStr)
+		if err != nil || len(h) != 32 {
+			log.Printf("ERROR: invalid transaction hash %q", hashStr)
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", "invalid transaction hash")))
+			return
+		}
+		var hash [32]byte
+		copy(hash[:], h)
+
+		bc := bcs.GetBlockchain()
+		w.Header().Add("Content-Type", "application/json")
+		if isProof {
+			proof := bc.InclusionProof(hash)
+			if proof == nil {
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, string(utils.JsonStatusWithReason("fail", "transaction is not confirmed")))
+				return
+			}
+			m, _ := proof.MarshalJSON()
+			io.WriteString(w, string(m[:]))
+			return
+		}
+
+		lookup := bc.LookupTransaction(hash)
+		m, _ := lookup.MarshalJSON()
+		if lookup.Status == block.TRANSACTION_STATUS_UNKNOWN {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// sの末尾がsuffixであれば取り除いたものとtrueを返す
+func cutSuffix(s string, suffix string) (string, bool) {
+	if !strings.HasSuffix(s, suffix) {
+		return s, false
+	}
+	return strings.TrimSuffix(s, suffix), true
+}
+
+// GETの場合は高さfromからlimit個のBlockを古い順に返すAPI。続きがあればnext_fromをfromに指定して取得する
+// PUTの場合は近隣ノードでマイニングされたBlockを受け取るAPI
+func (bcs *BlockchainServer) Blocks(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		var from, limit int
+		var err error
+		q := req.URL.Query()
+		if s := q.Get("from"); s != "" {
+			if from, err = strconv.Atoi(s); err != nil || from < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusWithReason("fail", "invalid from")))
+				return
+			}
+		}
+		if s := q.Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusWithReason("fail", "invalid limit")))
+				return
+			}
+		}
+
+		blocks, length := bcs.GetBlockchain().Blocks(from, limit)
+		// 続きがない場合はnext_fromを返さない
+		var nextFrom int
+		if n := from + len(blocks); len(blocks) > 0 && n < length {
+			nextFrom = n
+		}
+		m, _ := json.Marshal(struct {
+			Blocks   []*block.BlockInfo `json:"blocks"`
+			Length   int                `json:"length"`
+			NextFrom int                `json:"next_from,omitempty"`
+		}{
+			Blocks:   blocks,
+			Length:   length,
+			NextFrom: nextFrom,
+		})
+		io.WriteString(w, string(m))
+	case http.MethodPut:
+		decoder := json.NewDecoder(req.Body)
+		var b block.Block
+		err := decoder.Decode(&b)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
+			return
+		}
+
+		bc := bcs.GetBlockchain()
+		err = bc.AddBlock(&b)
+
+		// 繋がらないBlockが届いた場合に備えて、近隣ノードのチェーンと比べる
+		go bc.ResolveConflicts()
+
+		w.Header().Add("Content-Type", "application/json")
+		var m []byte
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			m = utils.JsonStatusWithReason("fail", err.Error())
+		} else {
+			m = utils.JsonStatus("success")
+		}
+		io.WriteString(w, string(m))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// 1つのBlockを返すAPI
+// パスは/blocks/{height}、/blocks/hash/{hash}、/blocks/latestのいずれか
+func (bcs *BlockchainServer) BlockByPath(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		path := strings.TrimPrefix(req.URL.Path, "/blocks/")
+		bc := bcs.GetBlockchain()
+
+		var bi *block.BlockInfo
+		if path == "latest" {
+			bi = bc.LatestBlock()
+		} else if hashStr := strings.TrimPrefix(path, "hash/"); hashStr != path {
+			h, err := hex.DecodeString(hashStr)
+			if err != nil || len(h) != 32 {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusWithReason("fail", "invalid block hash")))
+				return
+			}
+			var hash [32]byte
+			copy(hash[:], h)
+			bi = bc.BlockByHash(hash)
+		} else {
+			height, err := strconv.Atoi(path)
+			if err != nil || height < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusWithReason("fail", "invalid block height")))
+				return
+			}
+			bi = bc.BlockByHeight(height)
+		}
+
+		if bi == nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", "block not found")))
+			return
+		}
+		m, _ := json.Marshal(bi)
+		io.WriteString(w, string(m))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// 現在の近隣ノードの一覧を返すAPI
+func (bcs *BlockchainServer) Neighbors(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		neighbors := bcs.GetBlockchain().Neighbors()
+		m, _ := json.Marshal(struct {
+			Neighbors []string `json:"neighbors"`
+			Length    int      `json:"length"`
+		}{
+			Neighbors: neighbors,
+			Length:    len(neighbors),
+		})
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// 近隣ノードのチェーンと比べて、最も長い正しいチェーンに置き換えるAPI
+func (bcs *BlockchainServer) Consensus(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPut:
+		bc := bcs.GetBlockchain()
+		replaced := bc.ResolveConflicts()
+
+		w.Header().Add("Content-Type", "application/json")
+		if replaced {
+			io.WriteString(w, string(utils.JsonStatus("success")))
+		} else {
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+		}
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
 func (bcs *BlockchainServer) Mine(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -136,17 +493,109 @@ func (bcs *BlockchainServer) Mine(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// 自動で実行されたマイニングの結果を返すAPI
+// 自動マイニングを開始するAPI。既に実行中の場合も現在の状態を返す
 func (bcs *BlockchainServer) StartMine(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodPost:
+		bcs.miner.Start()
+		bcs.writeMinerStatus(w)
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// 自動マイニングを停止するAPI。実行中のProof of Workが終わってから返す
+func (bcs *BlockchainServer) StopMine(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodPost:
+		bcs.miner.Stop()
+		bcs.writeMinerStatus(w)
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// 自動マイニングの状態を返すAPI
+func (bcs *BlockchainServer) MineStatus(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
-		bc := bcs.GetBlockchain()
-		bc.StartMining()
+		bcs.writeMinerStatus(w)
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
 
-		var m []byte
-		m = utils.JsonStatus("succsess")
+func (bcs *BlockchainServer) writeMinerStatus(w http.ResponseWriter) {
+	m, _ := json.Marshal(bcs.miner.Status())
+	w.Header().Add("Content-Type", "application/json")
+	io.WriteString(w, string(m))
+}
 
+// アドレスが送金または受け取りをした承認済みのTransactionを新しい順に返すAPI
+// パスは/address/{blockchain_address}/transactions。前回のnext_cursorをcursorに指定すると続きを返す
+func (bcs *BlockchainServer) AddressTransactions(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
 		w.Header().Add("Content-Type", "application/json")
+		path := strings.TrimPrefix(req.URL.Path, "/address/")
+		blockchainAddress, ok := cutSuffix(path, "/transactions")
+		if !ok || blockchainAddress == "" || strings.Contains(blockchainAddress, "/") {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+
+		var cursor, limit int
+		var err error
+		q := req.URL.Query()
+		if s := q.Get("cursor"); s != "" {
+			if cursor, err = strconv.Atoi(s); err != nil || cursor < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusWithReason("fail", "invalid cursor")))
+				return
+			}
+		}
+		if s := q.Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusWithReason("fail", "invalid limit")))
+				return
+			}
+		}
+
+		history := bcs.GetBlockchain().AddressTransactions(blockchainAddress, cursor, limit)
+		m, _ := json.Marshal(history)
+		io.WriteString(w, string(m))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// クエリパラメータのBlockchainAddressが持つ未使用の出力を返すAPI。LEDGER_UTXOの場合だけ使える
+// 返したtx_hashとindexを入力に指定してTransactionを作る
+func (bcs *BlockchainServer) UTXOs(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		utxos := bcs.GetBlockchain().UTXOs()
+		if utxos == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", block.ErrLedgerMismatch.Error())))
+			return
+		}
+
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		m, _ := json.Marshal(struct {
+			Outputs []*block.UnspentOutput `json:"utxos"`
+			Amount  utils.Amount           `json:"amount"`
+		}{
+			Outputs: utxos.Unspent(blockchainAddress),
+			Amount:  utxos.Balance(blockchainAddress),
+		})
 		io.WriteString(w, string(m))
 	default:
 		log.Println("ERROR: Invalid HTTP Method")
@@ -155,13 +604,19 @@ func (bcs *BlockchainServer) StartMine(w http.ResponseWriter, req *http.Request)
 }
 
 // クエリパラメータのBlockchainAddressからamount取得
+// unconfirmed=trueの場合はtransactionPoolの未承認の分を含めた残高も返す
 func (bcs *BlockchainServer) Amount(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
 		blockchianAddress := req.URL.Query().Get("blockchain_address")
-		amount := bcs.GetBlockchain().CalculateTotalAmount(blockchianAddress)
+		bc := bcs.GetBlockchain()
+		amount := bc.CalculateTotalAmount(blockchianAddress)
 
 		ar := &block.AmountResponse{Amount: amount}
+		if unconfirmed, _ := strconv.ParseBool(req.URL.Query().Get("unconfirmed")); unconfirmed {
+			unconfirmedAmount := bc.CalculateUnconfirmedAmount(blockchianAddress)
+			ar.UnconfirmedAmount = &unconfirmedAmount
+		}
 		m, _ := ar.MarshalJSON()
 
 		w.Header().Add("Content-Type", "application/json")
@@ -173,10 +628,49 @@ func (bcs *BlockchainServer) Amount(w http.ResponseWriter, req *http.Request) {
 }
 
 func (bcs *BlockchainServer) Run() {
+	bc := bcs.GetBlockchain()
+	bc.Run()
+	bcs.miner = block.NewMiner(bc, time.Second*block.MINING_TIMER_SEC)
+
 	http.HandleFunc("/", bcs.GetChain)
 	http.HandleFunc("/transactions", bcs.Transactions)
+	http.HandleFunc("/transactions/", bcs.TransactionByHash)
+	http.HandleFunc("/blocks", bcs.Blocks)
+	http.HandleFunc("/blocks/", bcs.BlockByPath)
+	http.HandleFunc("/neighbors", bcs.Neighbors)
+	http.HandleFunc("/consensus", bcs.Consensus)
 	http.HandleFunc("/mine", bcs.Mine)
 	http.HandleFunc("/mine/start", bcs.StartMine)
+	http.HandleFunc("/mine/stop", bcs.StopMine)
+	http.HandleFunc("/mine/status", bcs.MineStatus)
 	http.HandleFunc("/amount", bcs.Amount)
-	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(bcs.port)), nil))
+	http.HandleFunc("/utxos", bcs.UTXOs)
+	http.HandleFunc("/address/", bcs.AddressTransactions)
+
+	server := &http.Server{Addr: "0.0.0.0:" + strconv.Itoa(int(bcs.port))}
+	done := make(chan struct{})
+	go bcs.shutdownOnSignal(server, done)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	// ListenAndServeはShutdownを呼んだ時点で戻るので、処理中のリクエストが終わるまで待つ
+	<-done
+}
+
+// SIGINT, SIGTERMを受け取ったら自動マイニングを止めてからサーバーを終了し、終わったらdoneを閉じる
+// マイニング中のBlockの書き込みが途中で終わらないようにするため
+func (bcs *BlockchainServer) shutdownOnSignal(server *http.Server, done chan struct{}) {
+	defer close(done)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	log.Println("action=shutdown, status=started")
+	bcs.miner.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+	log.Println("action=shutdown, status=finished")
 }
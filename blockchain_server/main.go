@@ -1,8 +1,11 @@
 package main
 
 import (
+	"blockchain-study/block"
 	"flag"
+	"fmt"
 	"log"
+	"strings"
 )
 
 func init() {
@@ -11,7 +14,26 @@ func init() {
 
 func main() {
 	port := flag.Uint("port", 5000, "TCP Port Number for Blockchain Server")
+	dataDir := flag.String("datadir", "", "Data Directory for Blockchain Storage (default \"data/<port>\")")
+	host := flag.String("host", "127.0.0.1", "Host Address used for Neighbor Discovery")
+	seeds := flag.String("seeds", "", "Comma-separated Seed Nodes (host:port)")
+	workers := flag.Int("workers", 0, "Number of Proof of Work Goroutines (default number of CPUs)")
+	ledger := flag.String("ledger", block.LEDGER_ACCOUNT, "Ledger Model (account or utxo)")
+	keystore := flag.String("keystore", "", "Keystore File for the Miner's Wallet (created if missing, passphrase from $"+KEYSTORE_PASSPHRASE_ENV+")")
 	flag.Parse()
-	app := NewBlockChainServer(uint16(*port))
+
+	// 同じマシンで複数ノードを動かせるよう、デフォルトはポートごとに分ける
+	if *dataDir == "" {
+		*dataDir = fmt.Sprintf("data/%d", *port)
+	}
+
+	var seedList []string
+	for _, seed := range strings.Split(*seeds, ",") {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			seedList = append(seedList, seed)
+		}
+	}
+
+	app := NewBlockChainServer(uint16(*port), *dataDir, *host, seedList, *workers, *ledger, *keystore)
 	app.Run()
 }
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 送金額や残高を表す型。1コインをCOIN(10^8)単位に分けた整数で持つ
+type Amount int64
+
+const (
+	// 1コインあたりの最小単位の数
+	COIN Amount = 100000000
+	// 小数点以下の桁数
+	AMOUNT_DECIMALS = 8
+	// 発行されうるコインの上限。1つの額や額の合計はこれを超えない
+	// int64の範囲より十分小さいので、上限以下の額をいくつか足してもあふれない
+	MAX_AMOUNT Amount = 21000000 * COIN
+)
+
+var (
+	ErrInvalidAmount  = errors.New("invalid amount")
+	ErrAmountOverflow = errors.New("amount exceeds the maximum supply")
+)
+
+// "1.5"のような10進数の文字列をAmountに変換する
+// 負の値、NaNや指数表記、小数点以下AMOUNT_DECIMALS桁を超える値、MAX_AMOUNTを超える値はエラーにする
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if (whole == "" && frac == "") || !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if len(frac) > AMOUNT_DECIMALS {
+		return 0, fmt.Errorf("%w: %q has more than %d decimal places", ErrInvalidAmount, s, AMOUNT_DECIMALS)
+	}
+
+	var w, f int64
+	var err error
+	if whole != "" {
+		w, err = strconv.ParseInt(whole, 10, 64)
+		if err != nil || w > int64(MAX_AMOUNT/COIN) {
+			return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+		}
+	}
+	if frac != "" {
+		f, _ = strconv.ParseInt(frac+strings.Repeat("0", AMOUNT_DECIMALS-len(frac)), 10, 64)
+	}
+	a := Amount(w)*COIN + Amount(f)
+	if a < 0 || a > MAX_AMOUNT {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+	}
+	return a, nil
+}
+
+// a+bを返す。どちらかが負の場合と、合計がMAX_AMOUNTを超える場合はErrAmountOverflowを返す
+// 比べる前に足すとint64があふれて小さな値になるので、足す前に確かめる
+func AddAmounts(a Amount, b Amount) (Amount, error) {
+	if a < 0 || b < 0 || a > MAX_AMOUNT-b {
+		return 0, fmt.Errorf("%w: %v + %v", ErrAmountOverflow, a, b)
+	}
+	return a + b, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// 末尾の0を除いた10進数の文字列にする(例: 150000000 -> "1.5")
+func (a Amount) String() string {
+	sign := ""
+	u := uint64(a)
+	if a < 0 {
+		sign = "-"
+		u = uint64(-a)
+	}
+	whole := u / uint64(COIN)
+	frac := u % uint64(COIN)
+	if frac == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	fracStr := strings.TrimRight(fmt.Sprintf("%0*d", AMOUNT_DECIMALS, frac), "0")
+	return fmt.Sprintf("%s%d.%s", sign, whole, fracStr)
+}
+
+// Jsonでは10進数の数値としてそのまま書き出す
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Jsonの数値(または文字列)をfloatを経由せずにそのまま読み込む
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{"0", 0},
+		{"1", COIN},
+		{"1.5", 150000000},
+		{".5", 50000000},
+		{"1.", COIN},
+		{"0.00000001", 1},
+		{" 2.25 ", 225000000},
+		{"21000000", MAX_AMOUNT},
+		{"20999999.99999999", MAX_AMOUNT - 1},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.in)
+		if err != nil {
+			t.Errorf("ParseAmount(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmount(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseAmountInvalid(t *testing.T) {
+	for _, in := range []string{
+		"", ".", "-1", "+1", "1e8", "NaN", "0x10", "1.2.3", "1,5",
+		// 小数点以下AMOUNT_DECIMALS桁を超える値は丸めずに拒否する
+		"0.000000001", "1.123456789",
+		// MAX_AMOUNTとint64を超える値
+		"21000000.00000001", "21000001", "92233720368.54775807", "9223372036854775808",
+	} {
+		if got, err := ParseAmount(in); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("ParseAmount(%q) = %d, %v, want ErrInvalidAmount", in, got, err)
+		}
+	}
+}
+
+func TestAddAmounts(t *testing.T) {
+	tests := []struct {
+		a, b    Amount
+		want    Amount
+		wantErr bool
+	}{
+		{1, 2, 3, false},
+		{MAX_AMOUNT, 0, MAX_AMOUNT, false},
+		{MAX_AMOUNT - 1, 1, MAX_AMOUNT, false},
+		{MAX_AMOUNT, 1, 0, true},
+		{-1, 1, 0, true},
+		{1, -1, 0, true},
+		// 足すとint64があふれて負になる値
+		{1<<63 - 1, 1, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := AddAmounts(tt.a, tt.b)
+		if tt.wantErr {
+			if !errors.Is(err, ErrAmountOverflow) {
+				t.Errorf("AddAmounts(%d, %d) = %d, %v, want ErrAmountOverflow", tt.a, tt.b, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("AddAmounts(%d, %d) = %d, %v, want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{0, "0"},
+		{COIN, "1"},
+		{150000000, "1.5"},
+		{1, "0.00000001"},
+		{-150000000, "-1.5"},
+		{MAX_AMOUNT, "21000000"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	// floatを経由すると誤差が出る値も、そのまま読み書きできる
+	for _, in := range []string{`0.1`, `"0.1"`, `20999999.99999999`} {
+		var a Amount
+		if err := json.Unmarshal([]byte(in), &a); err != nil {
+			t.Errorf("Unmarshal(%s) error: %v", in, err)
+			continue
+		}
+		m, _ := json.Marshal(a)
+		var b Amount
+		if err := json.Unmarshal(m, &b); err != nil || a != b {
+			t.Errorf("round trip of %s: got %s, %v", in, m, err)
+		}
+	}
+	var a Amount
+	if err := json.Unmarshal([]byte(`1e-9`), &a); err == nil {
+		t.Error("Unmarshal(1e-9) accepted")
+	}
+}
@@ -4,10 +4,17 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
 )
 
+var (
+	ErrInvalidPublicKey  = errors.New("invalid public key")
+	ErrInvalidPrivateKey = errors.New("invalid private key")
+	ErrInvalidSignature  = errors.New("invalid signature")
+)
+
 type Signature struct {
 	R *big.Int
 	S *big.Int
@@ -17,34 +24,110 @@ func (s *Signature) String() string {
 	return fmt.Sprintf("%064x%064x", s.R, s.S)
 }
 
-func String2BigIntTuple(s string) (big.Int, big.Int) {
-	bx, _ := hex.DecodeString(s[:64])
-	by, _ := hex.DecodeString(s[64:])
-
+// 64桁ずつの16進数を繋げた128桁の文字列を2つの整数に変換する
+func String2BigIntTuple(s string) (big.Int, big.Int, error) {
 	var bix big.Int
 	var biy big.Int
+	if len(s) != 128 {
+		return bix, biy, fmt.Errorf("length must be 128, got %d", len(s))
+	}
+	bx, err := hex.DecodeString(s[:64])
+	if err != nil {
+		return bix, biy, err
+	}
+	by, err := hex.DecodeString(s[64:])
+	if err != nil {
+		return bix, biy, err
+	}
 
 	_ = bix.SetBytes(bx)
 	_ = biy.SetBytes(by)
 
-	return bix, biy
+	return bix, biy, nil
 }
 
-func SignatureFromString(s string) *Signature {
-	x, y := String2BigIntTuple(s)
-	return &Signature{&x, &y}
+// 文字列の署名(r, sを64桁ずつ)を元の形に変換。r, sは1以上P-256の位数未満でなければならない
+func SignatureFromString(s string) (*Signature, error) {
+	r, ss, err := String2BigIntTuple(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	n := elliptic.P256().Params().N
+	if r.Sign() <= 0 || r.Cmp(n) >= 0 || ss.Sign() <= 0 || ss.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("%w: out of range", ErrInvalidSignature)
+	}
+	return &Signature{R: &r, S: &ss}, nil
 }
 
 // 文字列のpublicKeyを元の形に変換
-func PublicKeyFromString(s string) *ecdsa.PublicKey {
-	x, y := String2BigIntTuple(s)
-	return &ecdsa.PublicKey{elliptic.P256(), &x, &y}
+// X, Yを64桁ずつ繋げた128桁のほか、04を付けた130桁と、02か03で始まる圧縮した66桁を受け付ける
+// P-256の曲線上にない点はエラーにする
+func PublicKeyFromString(s string) (*ecdsa.PublicKey, error) {
+	curve := elliptic.P256()
+	switch len(s) {
+	case 66:
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+		x, y := elliptic.UnmarshalCompressed(curve, b)
+		if x == nil {
+			return nil, fmt.Errorf("%w: not a compressed point on P-256", ErrInvalidPublicKey)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case 130:
+		if s[:2] != "04" {
+			return nil, fmt.Errorf("%w: unknown prefix %q", ErrInvalidPublicKey, s[:2])
+		}
+		s = s[2:]
+	}
+
+	x, y, err := String2BigIntTuple(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+	if !curve.IsOnCurve(&x, &y) {
+		return nil, fmt.Errorf("%w: point is not on P-256", ErrInvalidPublicKey)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}, nil
 }
 
 // 文字列のprivateKeyを元の形に変換
-func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) *ecdsa.PrivateKey {
-	b, _ := hex.DecodeString(s[:])
+// publicKeyを渡した場合は、秘密鍵から求めた公開鍵と一致するか確かめる
+func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) (*ecdsa.PrivateKey, error) {
+	if len(s) == 0 || len(s) > 64 {
+		return nil, fmt.Errorf("%w: length must be 1 to 64, got %d", ErrInvalidPrivateKey, len(s))
+	}
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPrivateKey, err)
+	}
+	privateKey, err := PrivateKeyFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey != nil && (publicKey.X.Cmp(privateKey.X) != 0 || publicKey.Y.Cmp(privateKey.Y) != 0) {
+		return nil, fmt.Errorf("%w: does not match the public key", ErrInvalidPrivateKey)
+	}
+	return privateKey, nil
+}
+
+// ビッグエンディアンで32バイト以下の秘密鍵から公開鍵を求めてecdsa.PrivateKeyを作る
+// 0とP-256の位数以上の値はエラーにする
+func PrivateKeyFromBytes(b []byte) (*ecdsa.PrivateKey, error) {
+	if len(b) == 0 || len(b) > 32 {
+		return nil, fmt.Errorf("%w: length must be 1 to 32 bytes, got %d", ErrInvalidPrivateKey, len(b))
+	}
+	curve := elliptic.P256()
 	var bi big.Int
 	_ = bi.SetBytes(b)
-	return &ecdsa.PrivateKey{*publicKey, &bi}
+	if bi.Sign() == 0 || bi.Cmp(curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("%w: out of range", ErrInvalidPrivateKey)
+	}
+
+	x, y := curve.ScalarBaseMult(bi.FillBytes(make([]byte, 32)))
+	return &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: &bi}, nil
 }
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ノードの生存確認で接続を待つ時間
+const NEIGHBOR_DIAL_TIMEOUT = 500 * time.Millisecond
+
+// 指定したhost:portにTCPで接続できるかを確認する
+func IsFoundHost(host string, port uint16) bool {
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	conn, err := net.DialTimeout("tcp", target, NEIGHBOR_DIAL_TIMEOUT)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// IPv4アドレスの先頭3オクテット(例: "192.168.0.")を取り出す
+var PATTERN = regexp.MustCompile(`^((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\.){3}`)
+
+// myHostの最後のオクテットにstartIp〜endIpを足したIPと、startPort〜endPortの組み合わせを走査し、
+// 接続できたノードを"host:port"の形で返す。自分自身は含めない
+func FindNeighbors(myHost string, myPort uint16,
+	startIp uint8, endIp uint8, startPort uint16, endPort uint16) []string {
+	address := net.JoinHostPort(myHost, strconv.Itoa(int(myPort)))
+
+	prefixHost := PATTERN.FindString(myHost)
+	if prefixHost == "" {
+		return nil
+	}
+	lastIp, err := strconv.Atoi(myHost[len(prefixHost):])
+	if err != nil {
+		return nil
+	}
+
+	neighbors := make([]string, 0)
+	for port := startPort; port <= endPort; port += 1 {
+		for ip := int(startIp); ip <= int(endIp); ip += 1 {
+			if lastIp+ip > 255 {
+				break
+			}
+			guessHost := fmt.Sprintf("%s%d", prefixHost, lastIp+ip)
+			guessTarget := net.JoinHostPort(guessHost, strconv.Itoa(int(port)))
+			if guessTarget != address && IsFoundHost(guessHost, port) {
+				neighbors = append(neighbors, guessTarget)
+			}
+		}
+	}
+	return neighbors
+}
@@ -0,0 +1,61 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+const (
+	// NewWalletで作るアドレスのバージョン(0x00 for Main Network)と、base58にする前のバイト数
+	ADDRESS_VERSION = 0x00
+	ADDRESS_LENGTH  = 25
+)
+
+var (
+	ErrInvalidAddress  = errors.New("invalid blockchain address")
+	ErrAddressMismatch = errors.New("blockchain address does not match the public key")
+)
+
+// アドレスの長さ、バージョン、チェックサムを確かめて、公開鍵のhash160(20バイト)を返す
+func DecodeAddress(address string) ([]byte, error) {
+	b := base58.Decode(address)
+	if len(b) != ADDRESS_LENGTH {
+		return nil, fmt.Errorf("%w %q: length", ErrInvalidAddress, address)
+	}
+	if b[0] != ADDRESS_VERSION {
+		return nil, fmt.Errorf("%w %q: version", ErrInvalidAddress, address)
+	}
+	if !bytes.Equal(checksum(b[:21]), b[21:]) {
+		return nil, fmt.Errorf("%w %q: checksum mismatch", ErrInvalidAddress, address)
+	}
+	return b[1:21], nil
+}
+
+// 打ち間違えたアドレスに送金しないよう、正しい形式のアドレスかを確かめる
+func ValidateAddress(address string) error {
+	_, err := DecodeAddress(address)
+	return err
+}
+
+// アドレスが公開鍵から求めたものか確かめる
+func VerifyAddress(address string, publicKey *ecdsa.PublicKey) error {
+	if err := ValidateAddress(address); err != nil {
+		return err
+	}
+	if BlockchainAddressFromPublicKey(publicKey) != address {
+		return ErrAddressMismatch
+	}
+	return nil
+}
+
+// base58checkのチェックサム。sha256を2回かけた先頭4バイト
+func checksum(b []byte) []byte {
+	h1 := sha256.Sum256(b)
+	h2 := sha256.Sum256(h1[:])
+	return h2[:4]
+}
@@ -0,0 +1,425 @@
+package wallet
+
+import (
+	"blockchain-study/utils"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/ripemd160"
+)
+
+const (
+	// これ以上のindexはhardened。親の秘密鍵がなければ導出できない
+	HD_HARDENED = 0x80000000
+	// アカウントの鍵のパス。受け取り用のアドレスはこの下のHD_RECEIVE_CHAIN/{index}
+	HD_ACCOUNT_PATH  = "m/44'/0'/0'"
+	HD_RECEIVE_CHAIN = 0
+	// 残高をまとめる時に既定で調べるアドレスの数と上限
+	HD_DEFAULT_ADDRESSES = 20
+	HD_MAX_ADDRESSES     = 100
+)
+
+var (
+	// 拡張鍵の先頭4バイト。鍵はsecp256k1ではなくP-256なので、BIP32(Bitcoin)のxprv/xpubとは別の値にする
+	// base58にすると秘密鍵は"pprv"、公開鍵は"ppub"で始まる
+	xprvVersion = []byte{0x03, 0xe2, 0x59, 0x46}
+	xpubVersion = []byte{0x03, 0xe2, 0x5d, 0x80}
+)
+
+var (
+	ErrInvalidExtendedKey = errors.New("invalid extended key")
+	ErrInvalidPath        = errors.New("invalid derivation path")
+	ErrHardenedFromPublic = errors.New("cannot derive a hardened child from an extended public key")
+	ErrWatchOnly          = errors.New("watch-only wallet has no private keys")
+	ErrAddressesExhausted = errors.New("no more receive addresses")
+)
+
+// BIP32の拡張鍵。SLIP-0010に従いP-256の鍵を導出する
+// privateKeyがnilの場合は拡張公開鍵で、hardenedでない子の公開鍵だけを導出できる
+type ExtendedKey struct {
+	privateKey        *ecdsa.PrivateKey
+	publicKey         *ecdsa.PublicKey
+	chainCode         []byte
+	depth             byte
+	parentFingerprint [4]byte
+	childNumber       uint32
+}
+
+// シードからマスター鍵を作る
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	key, chainCode := masterKeyFromSeed(seed)
+	privateKey, err := utils.PrivateKeyFromBytes(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ExtendedKey{privateKey: privateKey, publicKey: &privateKey.PublicKey, chainCode: chainCode}, nil
+}
+
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.privateKey != nil
+}
+
+func (k *ExtendedKey) PrivateKey() *ecdsa.PrivateKey {
+	return k.privateKey
+}
+
+func (k *ExtendedKey) PublicKey() *ecdsa.PublicKey {
+	return k.publicKey
+}
+
+// 秘密鍵を取り除いた拡張公開鍵を返す
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	return &ExtendedKey{
+		publicKey:         k.publicKey,
+		chainCode:         k.chainCode,
+		depth:             k.depth,
+		parentFingerprint: k.parentFingerprint,
+		childNumber:       k.childNumber,
+	}
+}
+
+// indexの子の鍵を導出する。拡張公開鍵からは公開鍵だけの子を導出する
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	hardened := index >= HD_HARDENED
+	if hardened && !k.IsPrivate() {
+		return nil, ErrHardenedFromPublic
+	}
+
+	curve := elliptic.P256()
+	n := curve.Params().N
+	var data []byte
+	if hardened {
+		data = append([]byte{0x00}, k.privateKeyBytes()...)
+	} else {
+		data = compressPublicKey(k.publicKey)
+	}
+	data = append(data, ser32(index)...)
+
+	for {
+		mac := hmac.New(sha512.New, k.chainCode)
+		mac.Write(data)
+		i := mac.Sum(nil)
+		child := &ExtendedKey{
+			chainCode:         i[32:],
+			depth:             k.depth + 1,
+			parentFingerprint: k.fingerprint(),
+			childNumber:       index,
+		}
+
+		il := new(big.Int).SetBytes(i[:32])
+		if il.Cmp(n) < 0 {
+			if k.IsPrivate() {
+				d := new(big.Int).Add(il, k.privateKey.D)
+				d.Mod(d, n)
+				if d.Sign() != 0 {
+					privateKey, _ := utils.PrivateKeyFromBytes(d.FillBytes(make([]byte, 32)))
+					child.privateKey = privateKey
+					child.publicKey = &privateKey.PublicKey
+					return child, nil
+				}
+			} else {
+				x, y := curve.ScalarBaseMult(i[:32])
+				x, y = curve.Add(x, y, k.publicKey.X, k.publicKey.Y)
+				// 無限遠点の場合は(0, 0)になる
+				if x.Sign() != 0 || y.Sign() != 0 {
+					child.publicKey = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+					return child, nil
+				}
+			}
+		}
+
+		// 鍵が範囲外になった場合は0x01 || IR || indexでやり直す
+		data = append([]byte{0x01}, i[32:]...)
+		data = append(data, ser32(index)...)
+	}
+}
+
+// "m/44'/0'/0'"のようなパスに沿って導出する。'またはhの付いた番号はhardened
+// 拡張鍵自身を起点にするので、先頭のmは省略できる
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	key := k
+	for _, index := range indexes {
+		if key, err = key.Child(index); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// BIP32の形式(base58check)の文字列。秘密鍵があればpprv、なければppubで始まる
+func (k *ExtendedKey) String() string {
+	b := make([]byte, 0, 82)
+	if k.IsPrivate() {
+		b = append(b, xprvVersion...)
+	} else {
+		b = append(b, xpubVersion...)
+	}
+	b = append(b, k.depth)
+	b = append(b, k.parentFingerprint[:]...)
+	b = append(b, ser32(k.childNumber)...)
+	b = append(b, k.chainCode...)
+	if k.IsPrivate() {
+		b = append(b, 0x00)
+		b = append(b, k.privateKeyBytes()...)
+	} else {
+		b = append(b, compressPublicKey(k.publicKey)...)
+	}
+	return base58.Encode(append(b, checksum(b)...))
+}
+
+// Stringで書き出した拡張秘密鍵または拡張公開鍵の文字列を読み込む
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	b := base58.Decode(s)
+	if len(b) != 82 {
+		return nil, fmt.Errorf("%w: length", ErrInvalidExtendedKey)
+	}
+	payload := b[:78]
+	if !hmac.Equal(checksum(payload), b[78:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidExtendedKey)
+	}
+
+	k := &ExtendedKey{
+		depth:       payload[4],
+		childNumber: binary.BigEndian.Uint32(payload[9:13]),
+		chainCode:   append([]byte{}, payload[13:45]...),
+	}
+	copy(k.parentFingerprint[:], payload[5:9])
+	key := payload[45:78]
+
+	switch string(payload[:4]) {
+	case string(xprvVersion):
+		if key[0] != 0x00 {
+			return nil, fmt.Errorf("%w: private key", ErrInvalidExtendedKey)
+		}
+		privateKey, err := utils.PrivateKeyFromBytes(key[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidExtendedKey, err)
+		}
+		k.privateKey = privateKey
+		k.publicKey = &privateKey.PublicKey
+	case string(xpubVersion):
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), key)
+		if x == nil {
+			return nil, fmt.Errorf("%w: public key", ErrInvalidExtendedKey)
+		}
+		k.publicKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	default:
+		return nil, fmt.Errorf("%w: version", ErrInvalidExtendedKey)
+	}
+	return k, nil
+}
+
+// パスをindexの並びに変換する
+func ParsePath(path string) ([]uint32, error) {
+	segments := strings.Split(path, "/")
+	if segments[0] == "m" {
+		segments = segments[1:]
+	}
+	indexes := make([]uint32, 0, len(segments))
+	for _, segment := range segments {
+		var offset uint32 = 0
+		if strings.HasSuffix(segment, "'") || strings.HasSuffix(segment, "h") {
+			offset = HD_HARDENED
+			segment = segment[:len(segment)-1]
+		}
+		index, err := strconv.ParseUint(segment, 10, 32)
+		if err != nil || index >= HD_HARDENED {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		indexes = append(indexes, uint32(index)+offset)
+	}
+	return indexes, nil
+}
+
+func (k *ExtendedKey) privateKeyBytes() []byte {
+	return k.privateKey.D.FillBytes(make([]byte, 32))
+}
+
+// 親の鍵の識別子。圧縮した公開鍵のhash160の先頭4バイト
+func (k *ExtendedKey) fingerprint() [4]byte {
+	h := sha256.Sum256(compressPublicKey(k.publicKey))
+	r := ripemd160.New()
+	r.Write(h[:])
+	var fp [4]byte
+	copy(fp[:], r.Sum(nil))
+	return fp
+}
+
+func compressPublicKey(publicKey *ecdsa.PublicKey) []byte {
+	return elliptic.MarshalCompressed(elliptic.P256(), publicKey.X, publicKey.Y)
+}
+
+func ser32(i uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, i)
+	return b
+}
+
+// HDウォレットで導出したアドレス
+type HDAddress struct {
+	Index             uint32
+	Path              string
+	PublicKey         *ecdsa.PublicKey
+	BlockchainAddress string
+}
+
+func (a *HDAddress) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Index             uint32 `json:"index"`
+		Path              string `json:"path"`
+		PublicKey         string `json:"public_key"`
+		BlockchainAddress string `json:"blockchain_address"`
+	}{
+		Index:             a.Index,
+		Path:              a.Path,
+		PublicKey:         publicKeyStr(a.PublicKey),
+		BlockchainAddress: a.BlockchainAddress,
+	})
+}
+
+// 1つのシードから受け取り用のアドレスをいくつでも導出できるWallet
+// アカウントの拡張公開鍵(xpub)だけから作った場合は、アドレスは導出できるが署名はできない
+type HDWallet struct {
+	account  *ExtendedKey
+	receive  *ExtendedKey
+	mnemonic string
+}
+
+// ニーモニックから新しいHDウォレットを作る
+func NewHDWalletWithMnemonic(words int, passphrase string) (*HDWallet, error) {
+	mnemonic, err := NewMnemonic(words)
+	if err != nil {
+		return nil, err
+	}
+	return NewHDWalletFromMnemonic(mnemonic, passphrase)
+}
+
+// ニーモニックとパスフレーズから同じHDウォレットを作り直す
+func NewHDWalletFromMnemonic(mnemonic string, passphrase string) (*HDWallet, error) {
+	seed, mnemonic, err := seedFromMnemonic(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	account, err := master.Derive(HD_ACCOUNT_PATH)
+	if err != nil {
+		return nil, err
+	}
+	hw, err := newHDWallet(account)
+	if err != nil {
+		return nil, err
+	}
+	hw.mnemonic = mnemonic
+	return hw, nil
+}
+
+// アカウントのxpubまたはxprvからHDウォレットを作る。xpubの場合は見るだけのWalletになる
+func NewHDWalletFromExtendedKey(s string) (*HDWallet, error) {
+	account, err := ParseExtendedKey(s)
+	if err != nil {
+		return nil, err
+	}
+	return newHDWallet(account)
+}
+
+func newHDWallet(account *ExtendedKey) (*HDWallet, error) {
+	receive, err := account.Child(HD_RECEIVE_CHAIN)
+	if err != nil {
+		return nil, err
+	}
+	return &HDWallet{account: account, receive: receive}, nil
+}
+
+func (hw *HDWallet) Mnemonic() string {
+	return hw.mnemonic
+}
+
+func (hw *HDWallet) WatchOnly() bool {
+	return !hw.account.IsPrivate()
+}
+
+// アカウントの拡張公開鍵。これを渡せば署名できないままアドレスと残高を確認できる
+func (hw *HDWallet) Xpub() string {
+	return hw.account.Neuter().String()
+}
+
+// 受け取り用のindex番目のアドレス
+func (hw *HDWallet) Address(index uint32) (*HDAddress, error) {
+	key, err := hw.receive.Child(index)
+	if err != nil {
+		return nil, err
+	}
+	return &HDAddress{
+		Index:             index,
+		Path:              fmt.Sprintf("%s/%d/%d", HD_ACCOUNT_PATH, HD_RECEIVE_CHAIN, index),
+		PublicKey:         key.PublicKey(),
+		BlockchainAddress: BlockchainAddressFromPublicKey(key.PublicKey()),
+	}, nil
+}
+
+// fromからcount個のアドレス
+func (hw *HDWallet) Addresses(from uint32, count int) ([]*HDAddress, error) {
+	addresses := make([]*HDAddress, 0, count)
+	for i := 0; i < count; i++ {
+		a, err := hw.Address(from + uint32(i))
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses, nil
+}
+
+// まだ返していない新しい受け取り用のアドレスを返す
+// 返したアドレスの数はstoreにxpubごとに保存するので、作り直したHDウォレットでも続きから返す
+func (hw *HDWallet) NextAddress(store *HDIndexStore) (*HDAddress, error) {
+	index, err := store.next(hw.Xpub())
+	if err != nil {
+		return nil, err
+	}
+	return hw.Address(index)
+}
+
+// index番目のアドレスの秘密鍵を持つWallet。送金の署名に使う
+func (hw *HDWallet) Wallet(index uint32) (*Wallet, error) {
+	if hw.WatchOnly() {
+		return nil, ErrWatchOnly
+	}
+	key, err := hw.receive.Child(index)
+	if err != nil {
+		return nil, err
+	}
+	return NewWalletFromPrivateKey(key.PrivateKey()), nil
+}
+
+func (hw *HDWallet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Mnemonic    string `json:"mnemonic,omitempty"`
+		Xpub        string `json:"xpub"`
+		AccountPath string `json:"account_path"`
+		WatchOnly   bool   `json:"watch_only"`
+	}{
+		Mnemonic:    hw.Mnemonic(),
+		Xpub:        hw.Xpub(),
+		AccountPath: HD_ACCOUNT_PATH,
+		WatchOnly:   hw.WatchOnly(),
+	})
+}
@@ -0,0 +1,295 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type slip10Vector struct {
+	path        string
+	fingerprint string
+	chainCode   string
+	privateKey  string
+	publicKey   string
+}
+
+// SLIP-0010のnist256p1(P-256)のテストベクター
+var slip10Tests = []struct {
+	name    string
+	seed    string
+	vectors []slip10Vector
+}{
+	{
+		name: "test vector 1",
+		seed: "000102030405060708090a0b0c0d0e0f",
+		vectors: []slip10Vector{
+			{"m", "00000000",
+				"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+				"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+				"0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8"},
+			{"m/0'", "be6105b5",
+				"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+				"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c",
+				"0384610f5ecffe8fda089363a41f56a5c7ffc1d81b59a612d0d649b2d22355590c"},
+			{"m/0'/1", "9b02312f",
+				"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+				"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129",
+				"03526c63f8d0b4bbbf9c80df553fe66742df4676b241dabefdef67733e070f6844"},
+			{"m/0'/1/2'", "b98005c1",
+				"98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318",
+				"694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7",
+				"0359cf160040778a4b14c5f4d7b76e327ccc8c4a6086dd9451b7482b5a4972dda0"},
+			{"m/0'/1/2'/2", "0e9f3274",
+				"ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0",
+				"5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa",
+				"029f871f4cb9e1c97f9f4de9ccd0d4a2f2a171110c61178f84430062230833ff20"},
+			{"m/0'/1/2'/2/1000000000", "8b2b5c4b",
+				"b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059",
+				"21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119",
+				"02216cd26d31147f72427a453c443ed2cde8a1e53c9cc44e5ddf739725413fe3f4"},
+		},
+	},
+	{
+		name: "test vector 2",
+		seed: "fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542",
+		vectors: []slip10Vector{
+			{"m", "00000000",
+				"96cd4465a9644e31528eda3592aa35eb39a9527769ce1855beafc1b81055e75d",
+				"eaa31c2e46ca2962227cf21d73a7ef0ce8b31c756897521eb6c7b39796633357",
+				"02c9e16154474b3ed5b38218bb0463e008f89ee03e62d22fdcc8014beab25b48fa"},
+			{"m/0", "607f628f",
+				"84e9c258bb8557a40e0d041115b376dd55eda99c0042ce29e81ebe4efed9b86a",
+				"d7d065f63a62624888500cdb4f88b6d59c2927fee9e6d0cdff9cad555884df6e",
+				"039b6df4bece7b6c81e2adfeea4bcf5c8c8a6e40ea7ffa3cf6e8494c61a1fc82cc"},
+			{"m/0/2147483647'", "946d2a54",
+				"f235b2bc5c04606ca9c30027a84f353acf4e4683edbd11f635d0dcc1cd106ea6",
+				"96d2ec9316746a75e7793684ed01e3d51194d81a42a3276858a5b7376d4b94b9",
+				"02f89c5deb1cae4fedc9905f98ae6cbf6cbab120d8cb85d5bd9a91a72f4c068c76"},
+			{"m/0/2147483647'/1", "218182d8",
+				"7c0b833106235e452eba79d2bdd58d4086e663bc8cc55e9773d2b5eeda313f3b",
+				"974f9096ea6873a915910e82b29d7c338542ccde39d2064d1cc228f371542bbc",
+				"03abe0ad54c97c1d654c1852dfdc32d6d3e487e75fa16f0fd6304b9ceae4220c64"},
+			{"m/0/2147483647'/1/2147483646'", "931223e4",
+				"5794e616eadaf33413aa309318a26ee0fd5163b70466de7a4512fd4b1a5c9e6a",
+				"da29649bbfaff095cd43819eda9a7be74236539a29094cd8336b07ed8d4eff63",
+				"03cb8cb067d248691808cd6b5a5a06b48e34ebac4d965cba33e6dc46fe13d9b933"},
+			{"m/0/2147483647'/1/2147483646'/2", "956c4629",
+				"3bfb29ee8ac4484f09db09c2079b520ea5616df7820f071a20320366fbe226a7",
+				"bb0a77ba01cc31d77205d51d08bd313b979a71ef4de9b062f8958297e746bd67",
+				"020ee02e18967237cf62672983b253ee62fa4dd431f8243bfeccdf39dbe181387f"},
+		},
+	},
+	{
+		// 導出した鍵が範囲外になり、やり直す場合
+		name: "derivation retry",
+		seed: "000102030405060708090a0b0c0d0e0f",
+		vectors: []slip10Vector{
+			{"m/28578'", "be6105b5",
+				"e94c8ebe30c2250a14713212f6449b20f3329105ea15b652ca5bdfc68f6c65c2",
+				"06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669",
+				"02519b5554a4872e8c9c1c847115363051ec43e93400e030ba3c36b52a3e70a5b7"},
+			{"m/28578'/33941", "3e2b7bc6",
+				"9e87fe95031f14736774cd82f25fd885065cb7c358c1edf813c72af535e83071",
+				"092154eed4af83e078ff9b84322015aefe5769e31270f62c3f66c33888335f3a",
+				"0235bfee614c0d5b2cae260000bb1d0d84b270099ad790022c1ae0b2e782efe120"},
+		},
+	},
+	{
+		// シードから作ったマスター鍵が範囲外になり、やり直す場合
+		name: "seed retry",
+		seed: "a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446",
+		vectors: []slip10Vector{
+			{"m", "00000000",
+				"7762f9729fed06121fd13f326884c82f59aa95c57ac492ce8c9654e60efd130c",
+				"3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f",
+				"0383619fadcde31063d8c5cb00dbfe1713f3e6fa169d8541a798752a1c1ca0cb20"},
+		},
+	},
+}
+
+func TestSLIP10Vectors(t *testing.T) {
+	for _, tt := range slip10Tests {
+		seed, _ := hex.DecodeString(tt.seed)
+		master, err := NewMasterKey(seed)
+		if err != nil {
+			t.Fatalf("%s: NewMasterKey error: %v", tt.name, err)
+		}
+		for _, v := range tt.vectors {
+			k, err := master.Derive(v.path)
+			if err != nil {
+				t.Errorf("%s %s: Derive error: %v", tt.name, v.path, err)
+				continue
+			}
+			if got := hex.EncodeToString(k.parentFingerprint[:]); got != v.fingerprint {
+				t.Errorf("%s %s: fingerprint = %s, want %s", tt.name, v.path, got, v.fingerprint)
+			}
+			if got := hex.EncodeToString(k.chainCode); got != v.chainCode {
+				t.Errorf("%s %s: chain code = %s, want %s", tt.name, v.path, got, v.chainCode)
+			}
+			if got := hex.EncodeToString(k.privateKeyBytes()); got != v.privateKey {
+				t.Errorf("%s %s: private key = %s, want %s", tt.name, v.path, got, v.privateKey)
+			}
+			if got := hex.EncodeToString(compressPublicKey(k.PublicKey())); got != v.publicKey {
+				t.Errorf("%s %s: public key = %s, want %s", tt.name, v.path, got, v.publicKey)
+			}
+		}
+	}
+}
+
+// 拡張公開鍵から導出した子の公開鍵は、拡張秘密鍵から導出したものと同じになる
+func TestPublicChildDerivation(t *testing.T) {
+	seed, _ := hex.DecodeString(slip10Tests[0].seed)
+	master, _ := NewMasterKey(seed)
+	account, _ := master.Derive("m/0'/1/2'")
+	for _, index := range []uint32{0, 1, 2, 1000000000} {
+		private, _ := account.Child(index)
+		public, err := account.Neuter().Child(index)
+		if err != nil {
+			t.Fatalf("Child(%d) error: %v", index, err)
+		}
+		if public.IsPrivate() {
+			t.Errorf("Child(%d) of a public key has a private key", index)
+		}
+		if public.String() != private.Neuter().String() {
+			t.Errorf("Child(%d) = %s, want %s", index, public, private.Neuter())
+		}
+	}
+	if _, err := account.Neuter().Child(HD_HARDENED); !errors.Is(err, ErrHardenedFromPublic) {
+		t.Errorf("hardened Child of a public key error = %v, want ErrHardenedFromPublic", err)
+	}
+}
+
+func TestExtendedKeyString(t *testing.T) {
+	seed, _ := hex.DecodeString(slip10Tests[0].seed)
+	master, _ := NewMasterKey(seed)
+	key, _ := master.Derive("m/0'/1")
+	tests := []struct {
+		key    *ExtendedKey
+		prefix string
+	}{
+		{master, "pprv"},
+		{master.Neuter(), "ppub"},
+		{key, "pprv"},
+		{key.Neuter(), "ppub"},
+	}
+	for _, tt := range tests {
+		s := tt.key.String()
+		if !strings.HasPrefix(s, tt.prefix) {
+			t.Errorf("%s does not start with %s", s, tt.prefix)
+		}
+		parsed, err := ParseExtendedKey(s)
+		if err != nil {
+			t.Errorf("ParseExtendedKey(%s) error: %v", s, err)
+			continue
+		}
+		if parsed.String() != s || parsed.IsPrivate() != tt.key.IsPrivate() {
+			t.Errorf("ParseExtendedKey(%s) = %s", s, parsed)
+		}
+	}
+}
+
+func TestParseExtendedKeyInvalid(t *testing.T) {
+	seed, _ := hex.DecodeString(slip10Tests[0].seed)
+	master, _ := NewMasterKey(seed)
+	s := master.Neuter().String()
+	// 最後の文字を変えてチェックサムを合わなくする
+	last := "1"
+	if strings.HasSuffix(s, "1") {
+		last = "2"
+	}
+	for _, in := range []string{
+		"",
+		s[:len(s)-1],
+		s[:len(s)-1] + last,
+		// BitcoinのBIP32のテストベクター1のxpub。バージョンが違うので読み込まない
+		"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+	} {
+		if _, err := ParseExtendedKey(in); !errors.Is(err, ErrInvalidExtendedKey) {
+			t.Errorf("ParseExtendedKey(%q) error = %v, want ErrInvalidExtendedKey", in, err)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []uint32
+	}{
+		{"m/44'/0'/0'", []uint32{HD_HARDENED + 44, HD_HARDENED, HD_HARDENED}},
+		{"0/1h", []uint32{0, HD_HARDENED + 1}},
+		{"m/2147483647'", []uint32{0xffffffff}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePath(tt.path)
+		if err != nil || len(got) != len(tt.want) {
+			t.Errorf("ParsePath(%q) = %v, %v, want %v", tt.path, got, err, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("ParsePath(%q) = %v, want %v", tt.path, got, tt.want)
+				break
+			}
+		}
+	}
+	for _, path := range []string{"m/", "m/-1", "m/2147483648", "m/a", "m//0"} {
+		if _, err := ParsePath(path); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("ParsePath(%q) error = %v, want ErrInvalidPath", path, err)
+		}
+	}
+}
+
+// /walletで作るWalletの鍵は、同じニーモニックのHDウォレットの0番目のアドレスと同じ
+func TestWalletFromMnemonicIsFirstHDAddress(t *testing.T) {
+	mnemonic, err := NewMnemonic(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWalletFromMnemonic(mnemonic, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hw, err := NewHDWalletFromMnemonic(mnemonic, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := hw.Address(0)
+	if w.BlockchainAddress() != a.BlockchainAddress {
+		t.Errorf("wallet address = %s, want %s", w.BlockchainAddress(), a.BlockchainAddress)
+	}
+}
+
+func TestNextAddress(t *testing.T) {
+	name := filepath.Join(t.TempDir(), HD_INDEX_FILE)
+	store, err := LoadHDIndexStore(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hw, _ := NewHDWalletWithMnemonic(12, "")
+	watchOnly, _ := NewHDWalletFromExtendedKey(hw.Xpub())
+	for i := uint32(0); i < 3; i++ {
+		a, err := watchOnly.NextAddress(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := hw.Address(i)
+		if a.Index != i || a.BlockchainAddress != want.BlockchainAddress {
+			t.Errorf("NextAddress() = %d %s, want %d %s", a.Index, a.BlockchainAddress, i, want.BlockchainAddress)
+		}
+	}
+
+	// 読み込み直しても続きから返す
+	store, err = LoadHDIndexStore(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Issued(hw.Xpub()); got != 3 {
+		t.Errorf("Issued() = %d, want 3", got)
+	}
+	if a, _ := hw.NextAddress(store); a.Index != 3 {
+		t.Errorf("NextAddress() after reload = %d, want 3", a.Index)
+	}
+}
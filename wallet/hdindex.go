@@ -0,0 +1,74 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// HDIndexStoreを保存するファイルの名前
+const HD_INDEX_FILE = "hd_indexes.json"
+
+// HDウォレットのNextAddressで返した受け取り用のアドレスの数を、xpubごとに保存するファイル
+// wallet_serverを再起動しても同じアドレスを2回返さないようにする
+type HDIndexStore struct {
+	mux     sync.Mutex
+	name    string
+	indexes map[string]uint32
+}
+
+// nameのファイルから読み込む。ファイルがなければ空で始め、最初にアドレスを返した時に作る
+func LoadHDIndexStore(name string) (*HDIndexStore, error) {
+	store := &HDIndexStore{name: name, indexes: make(map[string]uint32)}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store.indexes); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return store, nil
+}
+
+// xpubについてNextAddressで返したアドレスの数。次に返すアドレスのindexと同じ
+func (s *HDIndexStore) Issued(xpub string) uint32 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.indexes[xpub]
+}
+
+// xpubの次のindexを返し、1つ進めてファイルに保存する
+// 保存できなかった場合は進めずにエラーを返す。同じindexを2回返さないよう、保存してから返す
+func (s *HDIndexStore) next(xpub string) (uint32, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	index := s.indexes[xpub]
+	if index >= HD_HARDENED {
+		return 0, ErrAddressesExhausted
+	}
+	s.indexes[xpub] = index + 1
+	if err := s.save(); err != nil {
+		s.indexes[xpub] = index
+		return 0, err
+	}
+	return index, nil
+}
+
+// 途中で止まっても壊れたファイルが残らないよう一時ファイルから置き換える
+// s.muxをロックした状態で呼ぶこと
+func (s *HDIndexStore) save() error {
+	m, err := json.MarshalIndent(s.indexes, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.name + ".tmp"
+	if err := os.WriteFile(tmp, m, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.name)
+}
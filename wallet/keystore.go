@@ -0,0 +1,183 @@
+package wallet
+
+import (
+	"blockchain-study/utils"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	KEYSTORE_VERSION = 1
+	KEYSTORE_KDF     = "scrypt"
+	KEYSTORE_CIPHER  = "aes-256-gcm"
+
+	// scryptのパラメータ。Nを大きくするほどパスフレーズの総当たりに時間がかかる
+	SCRYPT_N      = 1 << 15
+	SCRYPT_R      = 8
+	SCRYPT_P      = 1
+	SCRYPT_KEYLEN = 32
+	SCRYPT_SALT   = 32
+)
+
+var (
+	ErrKeystoreVersion = errors.New("unsupported keystore version")
+	ErrKeystoreFormat  = errors.New("invalid keystore format")
+	// パスフレーズが違う場合とファイルが改ざんされた場合は区別できない
+	ErrInvalidPassphrase = errors.New("could not decrypt keystore: wrong passphrase or corrupted file")
+)
+
+// パスフレーズで暗号化した秘密鍵を保存するJsonの形式
+// アドレスと公開鍵は暗号化せずに持ち、復号した鍵から求めたものと一致するか確認する
+type Keystore struct {
+	Version           int            `json:"version"`
+	BlockchainAddress string         `json:"blockchain_address"`
+	PublicKey         string         `json:"public_key"`
+	Crypto            KeystoreCrypto `json:"crypto"`
+}
+
+type KeystoreCrypto struct {
+	KDF        string          `json:"kdf"`
+	KDFParams  KeystoreKDFArgs `json:"kdfparams"`
+	Cipher     string          `json:"cipher"`
+	Nonce      string          `json:"nonce"`
+	Ciphertext string          `json:"ciphertext"`
+}
+
+type KeystoreKDFArgs struct {
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	KeyLen int    `json:"dklen"`
+	Salt   string `json:"salt"`
+}
+
+// 秘密鍵をパスフレーズで暗号化したKeystoreを返す
+// 鍵はscryptでパスフレーズから導出し、秘密鍵をAES-GCMで暗号化する。アドレスは改ざん検知のため追加データに含める
+func (w *Wallet) Encrypt(passphrase string) (*Keystore, error) {
+	salt := make([]byte, SCRYPT_SALT)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	args := KeystoreKDFArgs{N: SCRYPT_N, R: SCRYPT_R, P: SCRYPT_P, KeyLen: SCRYPT_KEYLEN, Salt: hex.EncodeToString(salt)}
+	aead, err := keystoreCipher(passphrase, args)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, 32)
+	w.privateKey.D.FillBytes(plaintext)
+	ciphertext := aead.Seal(nil, nonce, plaintext, []byte(w.blockchainAddress))
+
+	return &Keystore{
+		Version:           KEYSTORE_VERSION,
+		BlockchainAddress: w.blockchainAddress,
+		PublicKey:         w.PublicKeyStr(),
+		Crypto: KeystoreCrypto{
+			KDF:        KEYSTORE_KDF,
+			KDFParams:  args,
+			Cipher:     KEYSTORE_CIPHER,
+			Nonce:      hex.EncodeToString(nonce),
+			Ciphertext: hex.EncodeToString(ciphertext),
+		},
+	}, nil
+}
+
+// Keystoreを復号してWalletを返す
+func (ks *Keystore) Decrypt(passphrase string) (*Wallet, error) {
+	if ks.Version != KEYSTORE_VERSION {
+		return nil, fmt.Errorf("%w: %d", ErrKeystoreVersion, ks.Version)
+	}
+	if ks.Crypto.KDF != KEYSTORE_KDF || ks.Crypto.Cipher != KEYSTORE_CIPHER {
+		return nil, fmt.Errorf("%w: kdf %q, cipher %q", ErrKeystoreFormat, ks.Crypto.KDF, ks.Crypto.Cipher)
+	}
+	nonce, err := hex.DecodeString(ks.Crypto.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: nonce", ErrKeystoreFormat)
+	}
+	ciphertext, err := hex.DecodeString(ks.Crypto.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: ciphertext", ErrKeystoreFormat)
+	}
+	aead, err := keystoreCipher(passphrase, ks.Crypto.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: nonce", ErrKeystoreFormat)
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(ks.BlockchainAddress))
+	if err != nil {
+		return nil, ErrInvalidPassphrase
+	}
+	privateKey, err := utils.PrivateKeyFromBytes(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	w := NewWalletFromPrivateKey(privateKey)
+	if w.BlockchainAddress() != ks.BlockchainAddress || w.PublicKeyStr() != ks.PublicKey {
+		return nil, fmt.Errorf("%w: key does not match blockchain_address", ErrKeystoreFormat)
+	}
+	return w, nil
+}
+
+// Walletを暗号化してファイルに保存する。途中で止まっても壊れたファイルが残らないよう一時ファイルから置き換える
+func (w *Wallet) SaveKeystore(name string, passphrase string) error {
+	ks, err := w.Encrypt(passphrase)
+	if err != nil {
+		return err
+	}
+	m, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, m, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// ファイルからKeystoreを読み込んで復号する
+func LoadKeystore(name string, passphrase string) (*Wallet, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var ks Keystore
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeystoreFormat, err)
+	}
+	return ks.Decrypt(passphrase)
+}
+
+func keystoreCipher(passphrase string, args KeystoreKDFArgs) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(args.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: salt", ErrKeystoreFormat)
+	}
+	if args.KeyLen != SCRYPT_KEYLEN {
+		return nil, fmt.Errorf("%w: dklen %d", ErrKeystoreFormat, args.KeyLen)
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, args.N, args.R, args.P, args.KeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeystoreFormat, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
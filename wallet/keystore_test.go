@@ -0,0 +1,76 @@
+package wallet
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	w := NewWallet()
+	name := filepath.Join(t.TempDir(), "miner.json")
+	if err := w.SaveKeystore(name, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("keystore permission = %o, want 600", perm)
+	}
+
+	loaded, err := LoadKeystore(name, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.PrivateKeyStr() != w.PrivateKeyStr() || loaded.BlockchainAddress() != w.BlockchainAddress() {
+		t.Errorf("LoadKeystore() = %s, want %s", loaded.BlockchainAddress(), w.BlockchainAddress())
+	}
+
+	if _, err := LoadKeystore(name, "wrong horse"); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Errorf("LoadKeystore() with a wrong passphrase error = %v, want ErrInvalidPassphrase", err)
+	}
+}
+
+func TestKeystoreDecryptInvalid(t *testing.T) {
+	w := NewWallet()
+	other := NewWallet()
+	tests := []struct {
+		name   string
+		modify func(ks *Keystore)
+		want   error
+	}{
+		{"version", func(ks *Keystore) { ks.Version = 2 }, ErrKeystoreVersion},
+		{"kdf", func(ks *Keystore) { ks.Crypto.KDF = "pbkdf2" }, ErrKeystoreFormat},
+		{"cipher", func(ks *Keystore) { ks.Crypto.Cipher = "aes-128-ctr" }, ErrKeystoreFormat},
+		{"nonce", func(ks *Keystore) { ks.Crypto.Nonce = "00" }, ErrKeystoreFormat},
+		{"salt", func(ks *Keystore) { ks.Crypto.KDFParams.Salt = "zz" }, ErrKeystoreFormat},
+		{"dklen", func(ks *Keystore) { ks.Crypto.KDFParams.KeyLen = 16 }, ErrKeystoreFormat},
+		// アドレスは追加データに含めているので、書き換えると復号できない
+		{"address", func(ks *Keystore) { ks.BlockchainAddress = other.BlockchainAddress() }, ErrInvalidPassphrase},
+		{"ciphertext", func(ks *Keystore) {
+			b := []byte(ks.Crypto.Ciphertext)
+			if b[0] == '0' {
+				b[0] = '1'
+			} else {
+				b[0] = '0'
+			}
+			ks.Crypto.Ciphertext = string(b)
+		}, ErrInvalidPassphrase},
+		{"public key", func(ks *Keystore) { ks.PublicKey = other.PublicKeyStr() }, ErrKeystoreFormat},
+	}
+
+	ks, err := w.Encrypt("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		c := *ks
+		tt.modify(&c)
+		if _, err := c.Decrypt("passphrase"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Decrypt() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// SLIP-0010でP-256の鍵をシードから導出する時のHMACの鍵
+const P256_SEED_KEY = "Nist256p1 seed"
+
+var (
+	ErrMnemonicLength  = errors.New("mnemonic must be 12 or 24 words")
+	ErrInvalidMnemonic = errors.New("invalid mnemonic")
+)
+
+// 12語または24語のBIP39のニーモニックを新しく作る
+func NewMnemonic(words int) (string, error) {
+	var bits int
+	switch words {
+	case 12:
+		bits = 128
+	case 24:
+		bits = 256
+	default:
+		return "", ErrMnemonicLength
+	}
+	entropy, err := bip39.NewEntropy(bits)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// ニーモニックから作ったWalletを返す。ニーモニックはWalletのJsonにも含める
+func NewWalletWithMnemonic(words int, passphrase string) (*Wallet, error) {
+	mnemonic, err := NewMnemonic(words)
+	if err != nil {
+		return nil, err
+	}
+	return NewWalletFromMnemonic(mnemonic, passphrase)
+}
+
+// ニーモニックとパスフレーズから同じ鍵とアドレスのWalletを作り直す
+// 単語が一覧にない場合やチェックサムが合わない場合はErrInvalidMnemonicを返す
+// 鍵は同じニーモニックのHDウォレットの受け取り用の0番目のアドレス(m/44'/0'/0'/0/0)と同じ
+func NewWalletFromMnemonic(mnemonic string, passphrase string) (*Wallet, error) {
+	hw, err := NewHDWalletFromMnemonic(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	w, err := hw.Wallet(0)
+	if err != nil {
+		return nil, err
+	}
+	w.mnemonic = hw.Mnemonic()
+	return w, nil
+}
+
+// ニーモニックを確認してBIP39のシードを返す。2つ目の戻り値は空白と大文字小文字を揃えたニーモニック
+func seedFromMnemonic(mnemonic string, passphrase string) ([]byte, string, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) != 12 && len(words) != 24 {
+		return nil, "", ErrMnemonicLength
+	}
+	for _, word := range words {
+		if _, ok := bip39.GetWordIndex(word); !ok {
+			return nil, "", fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, word)
+		}
+	}
+	mnemonic = strings.Join(words, " ")
+	// 打ち間違いで別の単語になった場合はチェックサムが合わなくなる
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: checksum mismatch", ErrInvalidMnemonic)
+	}
+	return seed, mnemonic, nil
+}
+
+func (w *Wallet) Mnemonic() string {
+	return w.mnemonic
+}
+
+// SLIP-0010のマスター鍵とchain codeを返す
+// 鍵が0またはP-256の位数以上になった場合は、HMACの結果をもう一度HMACにかけてやり直す
+func masterKeyFromSeed(seed []byte) ([]byte, []byte) {
+	n := elliptic.P256().Params().N
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, []byte(P256_SEED_KEY))
+		mac.Write(data)
+		i := mac.Sum(nil)
+		k := new(big.Int).SetBytes(i[:32])
+		if k.Sign() != 0 && k.Cmp(n) < 0 {
+			return i[:32], i[32:]
+		}
+		data = i
+	}
+}
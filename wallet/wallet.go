@@ -2,11 +2,13 @@ package wallet
 
 import (
 	"blockchain-study/utils"
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/btcsuite/btcutil/base58"
@@ -17,19 +19,31 @@ type Wallet struct {
 	privateKey        *ecdsa.PrivateKey
 	publicKey         *ecdsa.PublicKey
 	blockchainAddress string
+	// ニーモニックから作った場合だけ持つ
+	mnemonic string
 }
 
 func NewWallet() *Wallet {
 	// 1. Creating ECDSA private key (32 bytes) public key (64 bytes)
-	w := new(Wallet)
 	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return NewWalletFromPrivateKey(privateKey)
+}
+
+// 既存の秘密鍵からWalletを作る。keystoreから読み込んだ鍵などに使う
+func NewWalletFromPrivateKey(privateKey *ecdsa.PrivateKey) *Wallet {
+	w := new(Wallet)
 	w.privateKey = privateKey
 	w.publicKey = &w.privateKey.PublicKey
+	w.blockchainAddress = BlockchainAddressFromPublicKey(w.publicKey)
+	return w
+}
 
+// 公開鍵からアドレスを求める。NewWalletのほか、Transactionの送金元のアドレスと公開鍵が合っているかの確認にも使う
+func BlockchainAddressFromPublicKey(publicKey *ecdsa.PublicKey) string {
 	// 2. Perform SHA-256 hashing on the public key (32 bytes).
 	h2 := sha256.New()
-	h2.Write(w.publicKey.X.Bytes())
-	h2.Write(w.publicKey.Y.Bytes())
+	h2.Write(publicKey.X.Bytes())
+	h2.Write(publicKey.Y.Bytes())
 	digest2 := h2.Sum(nil)
 
 	// 3. Perform RIPEMD-160 hashing on the result of SHA-256 (20 bytes).
@@ -61,10 +75,7 @@ func NewWallet() *Wallet {
 	copy(dc8[21:], chsum[:])
 
 	// 9. Convert the result from a byte string into base58.
-	address := base58.Encode(dc8)
-	w.blockchainAddress = address
-
-	return w
+	return base58.Encode(dc8)
 }
 
 func (w *Wallet) PrivateKey() *ecdsa.PrivateKey {
@@ -79,7 +90,11 @@ func (w *Wallet) PublicKey() *ecdsa.PublicKey {
 }
 
 func (w *Wallet) PublicKeyStr() string {
-	return fmt.Sprintf("%064x%064x", w.publicKey.X.Bytes(), w.publicKey.Y.Bytes())
+	return publicKeyStr(w.publicKey)
+}
+
+func publicKeyStr(publicKey *ecdsa.PublicKey) string {
+	return fmt.Sprintf("%064x%064x", publicKey.X.Bytes(), publicKey.Y.Bytes())
 }
 
 func (w *Wallet) BlockchainAddress() string {
@@ -91,68 +106,189 @@ func (w *Wallet) MarshalJSON() ([]byte, error) {
 		PrivateKey        string `json:"private_key"`
 		PublicKey         string `json:"public_key"`
 		BlockchainAddress string `json:"blockchain_address"`
+		Mnemonic          string `json:"mnemonic,omitempty"`
 	}{
 		PrivateKey:        w.PrivateKeyStr(),
 		PublicKey:         w.PublicKeyStr(),
 		BlockchainAddress: w.BlockchainAddress(),
+		Mnemonic:          w.Mnemonic(),
 	})
 }
 
+var (
+	ErrMissingFields  = errors.New("missing field(s)")
+	ErrInvalidPayload = errors.New("invalid payload")
+)
+
 type Transaction struct {
 	senderPrivateKey           *ecdsa.PrivateKey
 	senderPublickKey           *ecdsa.PublicKey
 	senderBlockchainAddress    string
 	recipientBlockchainAddress string
-	value                      float32
+	value                      utils.Amount
+	fee                        utils.Amount
+	nonce                      uint64
 }
 
+// nonceは送金した人ごとに一度しか使えない番号。同じ署名付きTransactionの再送を防ぐ
+// feeはマイニングした人に支払う手数料。0の場合は署名の対象に含めない
 func NewTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey,
-	sender string, recipient string, value float32) *Transaction {
+	sender string, recipient string, value utils.Amount, fee utils.Amount, nonce uint64) *Transaction {
 	return &Transaction{
 		senderPrivateKey:           privateKey,
 		senderPublickKey:           publicKey,
 		senderBlockchainAddress:    sender,
 		recipientBlockchainAddress: recipient,
 		value:                      value,
+		fee:                        fee,
+		nonce:                      nonce,
 	}
 }
 
+// 署名の対象になるバイト列。blockchain_serverのTransactionと同じJson
+// クライアント側で署名する場合はこのバイト列のsha256にECDSA(P-256)で署名する
+func (t *Transaction) SignedPayload() []byte {
+	m, _ := json.Marshal(t)
+	return m
+}
+
 // トランザクションへの署名を生成して返す。
 func (t *Transaction) GenerateSignature() *utils.Signature {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
+	h := sha256.Sum256(t.SignedPayload())
 	r, s, _ := ecdsa.Sign(rand.Reader, t.senderPrivateKey, h[:])
 
-	return &utils.Signature{r, s}
+	return &utils.Signature{R: r, S: s}
+}
+
+func (t *Transaction) SenderBlockchainAddress() string {
+	return t.senderBlockchainAddress
+}
+
+func (t *Transaction) RecipientBlockchainAddress() string {
+	return t.recipientBlockchainAddress
+}
+
+func (t *Transaction) Value() utils.Amount {
+	return t.value
+}
+
+func (t *Transaction) Fee() utils.Amount {
+	return t.fee
+}
+
+func (t *Transaction) Nonce() uint64 {
+	return t.nonce
 }
 
 func (t *Transaction) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Sender    string  `json:"sender_blockchain_address"`
-		Recipient string  `json:"recipient_blockchain_address"`
-		Value     float32 `json:"value"`
+		Sender    string       `json:"sender_blockchain_address"`
+		Recipient string       `json:"recipient_blockchain_address"`
+		Value     utils.Amount `json:"value"`
+		Fee       utils.Amount `json:"fee,omitempty"`
+		Nonce     uint64       `json:"nonce"`
 	}{
 		Sender:    t.senderBlockchainAddress,
 		Recipient: t.recipientBlockchainAddress,
 		Value:     t.value,
+		Fee:       t.fee,
+		Nonce:     t.nonce,
 	})
 }
 
+// 鍵を持たないTransactionとして読み込む。クライアントが署名したpayloadを受け取る時に使う
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	v := &struct {
+		Sender    *string       `json:"sender_blockchain_address"`
+		Recipient *string       `json:"recipient_blockchain_address"`
+		Value     *utils.Amount `json:"value"`
+		Fee       utils.Amount  `json:"fee"`
+		Nonce     *uint64       `json:"nonce"`
+	}{}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	if v.Sender == nil || v.Recipient == nil || v.Value == nil || v.Nonce == nil {
+		return ErrMissingFields
+	}
+	t.senderBlockchainAddress = *v.Sender
+	t.recipientBlockchainAddress = *v.Recipient
+	t.value = *v.Value
+	t.fee = v.Fee
+	t.nonce = *v.Nonce
+	return nil
+}
+
+// 署名前のTransactionを作るリクエスト。秘密鍵はwallet_serverに送らない
 type TransactionRequest struct {
-	SenderPrivateKey           *string `json:"sender_private_key"`
 	SenderBlockchainAddress    *string `json:"sender_blockchain_address"`
 	RecipientBlockchainAddress *string `json:"recipient_blockchain_address"`
-	SenderPublicKey            *string `json:"sender_public_key"`
 	Value                      *string `json:"value"`
+	// 省略できる。省略された場合は手数料なし
+	Fee *string `json:"fee"`
 }
 
-func (tr *TransactionRequest) Validate() bool {
-	if tr.SenderPrivateKey == nil ||
-		tr.SenderBlockchainAddress == nil ||
+func (tr *TransactionRequest) Validate() error {
+	if tr.SenderBlockchainAddress == nil ||
 		tr.RecipientBlockchainAddress == nil ||
-		tr.SenderPublicKey == nil ||
 		tr.Value == nil {
-		return false
+		return ErrMissingFields
+	}
+	if err := ValidateAddress(*tr.SenderBlockchainAddress); err != nil {
+		return fmt.Errorf("sender: %w", err)
+	}
+	if err := ValidateAddress(*tr.RecipientBlockchainAddress); err != nil {
+		return fmt.Errorf("recipient: %w", err)
 	}
-	return true
+	return nil
+}
+
+// クライアントが署名したTransactionのリクエスト
+// Payloadは署名前のTransactionとして受け取ったものをそのまま送る
+type SignedTransactionRequest struct {
+	Payload         *string `json:"payload"`
+	SenderPublicKey *string `json:"sender_public_key"`
+	Signature       *string `json:"signature"`
+}
+
+// payloadが/transaction/unsignedで作った形のままか、公開鍵と署名が読み込めるか、
+// アドレスが正しい形式で送金する人のアドレスが公開鍵から求めたものかを確かめる
+func (tr *SignedTransactionRequest) Validate() error {
+	if tr.Payload == nil ||
+		tr.SenderPublicKey == nil ||
+		tr.Signature == nil {
+		return ErrMissingFields
+	}
+
+	// payloadは/transaction/unsignedが返したものと同じバイト列でなければ署名が合わない
+	var t Transaction
+	if err := json.Unmarshal([]byte(*tr.Payload), &t); err != nil || !bytes.Equal(t.SignedPayload(), []byte(*tr.Payload)) {
+		return ErrInvalidPayload
+	}
+	if err := ValidateAddress(t.recipientBlockchainAddress); err != nil {
+		return fmt.Errorf("recipient: %w", err)
+	}
+	publicKey, err := utils.PublicKeyFromString(*tr.SenderPublicKey)
+	if err != nil {
+		return err
+	}
+	if _, err := utils.SignatureFromString(*tr.Signature); err != nil {
+		return err
+	}
+	if err := VerifyAddress(t.senderBlockchainAddress, publicKey); err != nil {
+		return fmt.Errorf("sender: %w", err)
+	}
+	return nil
+}
+
+// ニーモニックから作るWalletのリクエスト
+// 新しく作る場合はWords(12または24、省略した場合は12)、作り直す場合はMnemonicを指定する
+type WalletRequest struct {
+	Words      *int    `json:"words"`
+	Mnemonic   *string `json:"mnemonic"`
+	Passphrase string  `json:"passphrase"`
+	// HDウォレットの場合に秘密鍵を返すアドレスのindex。省略した場合は0
+	Index *uint32 `json:"index"`
+	// 新しい受け取り用のアドレスを導出するアカウントの拡張公開鍵
+	Xpub *string `json:"xpub"`
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 )
 
@@ -12,8 +13,14 @@ func init() {
 func main() {
 	port := flag.Uint("port", 8080, "TCP Port Number for Wallet Server")
 	gateway := flag.String("gateway", "http://127.0.0.1:5000", "Blockchain Gateway")
+	dataDir := flag.String("datadir", "", "Data Directory for Wallet Server (default \"data/wallet/<port>\")")
 	flag.Parse()
 
-	app := NewWalletServer(uint16(*port), *gateway)
+	// blockchain_serverのデータと分け、ポートごとにも分ける
+	if *dataDir == "" {
+		*dataDir = fmt.Sprintf("data/wallet/%d", *port)
+	}
+
+	app := NewWalletServer(uint16(*port), *gateway, *dataDir)
 	app.Run()
 }
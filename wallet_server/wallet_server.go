@@ -5,15 +5,21 @@ import (
 	"blockchain-study/utils"
 	"blockchain-study/wallet"
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const tempDir = "wallet_server/templates"
@@ -21,10 +27,13 @@ const tempDir = "wallet_server/templates"
 type WalletServer struct {
 	port    uint16
 	gateway string
+	dataDir string
+	// HDウォレットの/wallet/hd/nextで返したアドレスの数。Runで読み込む
+	hdIndexes *wallet.HDIndexStore
 }
 
-func NewWalletServer(port uint16, gateway string) *WalletServer {
-	return &WalletServer{port, gateway}
+func NewWalletServer(port uint16, gateway string, dataDir string) *WalletServer {
+	return &WalletServer{port: port, gateway: gateway, dataDir: dataDir}
 }
 
 func (ws *WalletServer) Port() uint16 {
@@ -35,6 +44,10 @@ func (ws *WalletServer) Gateway() string {
 	return ws.gateway
 }
 
+func (ws *WalletServer) DataDir() string {
+	return ws.dataDir
+}
+
 func (ws *WalletServer) Index(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -51,11 +64,30 @@ func (ws *WalletServer) Index(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// ニーモニックから新しいWalletを作るAPI
+// Bodyは省略でき、words(12または24)とpassphraseを指定できる。返したmnemonicで/wallet/restoreから作り直せる
 func (ws *WalletServer) Wallet(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPost:
 		w.Header().Add("Content-Type", "application/json")
-		myWallet := wallet.NewWallet()
+		var wr wallet.WalletRequest
+		if err := json.NewDecoder(req.Body).Decode(&wr); err != nil && err != io.EOF {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		words := 12
+		if wr.Words != nil {
+			words = *wr.Words
+		}
+		myWallet, err := wallet.NewWalletWithMnemonic(words, wr.Passphrase)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
+			return
+		}
 		m, _ := myWallet.MarshalJSON()
 		io.WriteString(w, string(m[:]))
 	default:
@@ -64,48 +96,138 @@ func (ws *WalletServer) Wallet(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// クライアントが発行した新規トランザクションを受け取るAPI
-func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Request) {
+// ニーモニックとパスフレーズからWalletを作り直すAPI
+func (ws *WalletServer) RestoreWallet(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+		var wr wallet.WalletRequest
+		if err := json.NewDecoder(req.Body).Decode(&wr); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if wr.Mnemonic == nil {
+			log.Printf("ERROR %v", "missing field(s)")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", "missing field(s)")))
+			return
+		}
+		myWallet, err := wallet.NewWalletFromMnemonic(*wr.Mnemonic, wr.Passphrase)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
+			return
+		}
+		m, _ := myWallet.MarshalJSON()
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// 署名前のTransactionを作るAPI
+// 返したpayloadのsha256にクライアントが秘密鍵で署名し、payloadと一緒に/transactionへPOSTする
+func (ws *WalletServer) UnsignedTransaction(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
 		decoder := json.NewDecoder(req.Body)
 		var t wallet.TransactionRequest
 		err := decoder.Decode(&t)
 		if err != nil {
 			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
 			io.WriteString(w, string(utils.JsonStatus("fail")))
 			return
 		}
-		if !t.Validate() {
-			log.Printf("ERROR %v", "missing field(s)")
+		if err := t.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
 			return
 		}
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
-		value, err := strconv.ParseFloat(*t.Value, 32)
+		value, err := utils.ParseAmount(*t.Value)
 		if err != nil {
-			log.Println("ERROR: parse error")
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
 			return
 		}
+		var fee utils.Amount = 0
+		if t.Fee != nil && *t.Fee != "" {
+			fee, err = utils.ParseAmount(*t.Fee)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatusWithReason("fail", "fee: "+err.Error())))
+				return
+			}
+		}
 
-		value32 := float32(value)
+		// nonceには現在時刻を使い、同じ内容の送金でも毎回別のTransactionになるようにする
+		nonce := uint64(time.Now().UnixNano())
+		transaction := wallet.NewTransaction(nil, nil,
+			*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value, fee, nonce)
+		payload := transaction.SignedPayload()
+		m, _ := json.Marshal(struct {
+			Message     string `json:"message"`
+			Payload     string `json:"payload"`
+			PayloadHash string `json:"payload_hash"`
+		}{
+			Message:     "success",
+			Payload:     string(payload),
+			PayloadHash: fmt.Sprintf("%x", sha256.Sum256(payload)),
+		})
+		io.WriteString(w, string(m))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
 
+// クライアントが署名したトランザクションを受け取り、blockchain_serverへ送るAPI
+// 署名の検証はblockchain_serverで行う
+func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
 		w.Header().Add("Content-Type", "application/json")
+		decoder := json.NewDecoder(req.Body)
+		var t wallet.SignedTransactionRequest
+		err := decoder.Decode(&t)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if err := t.Validate(); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
+			return
+		}
 
-		// 送信されてきたデータを新しいTransactionとして登録
-		transaction := wallet.NewTransaction(privateKey, publicKey,
-			*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value32)
-		signature := transaction.GenerateSignature()
-		signatureStr := signature.String()
+		var transaction wallet.Transaction
+		json.Unmarshal([]byte(*t.Payload), &transaction)
+		sender := transaction.SenderBlockchainAddress()
+		recipient := transaction.RecipientBlockchainAddress()
+		value := transaction.Value()
+		fee := transaction.Fee()
+		nonce := transaction.Nonce()
 
 		// blockchain_server側へ送信するRequestを作成
 		bt := &block.TransactionRequest{
-			SenderBlockchainAddress:    t.SenderBlockchainAddress,
-			RecipientBlockchainAddress: t.RecipientBlockchainAddress,
+			SenderBlockchainAddress:    &sender,
+			RecipientBlockchainAddress: &recipient,
 			SenderPublicKey:            t.SenderPublicKey,
-			Value:                      &value32,
-			Signature:                  &signatureStr,
+			Value:                      &value,
+			Fee:                        &fee,
+			Nonce:                      &nonce,
+			Signature:                  t.Signature,
 		}
 
 		// transaciton内容をJsonへ
@@ -113,12 +235,36 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Reque
 		buf := bytes.NewBuffer(m)
 
 		// 作成したTransactionRequest内容をBodyに含めてblockchain_server側へリクエストをPostで飛ばす
-		resp, _ := http.Post(ws.Gateway()+"/transactions", "application/json", buf)
+		resp, err := http.Post(ws.Gateway()+"/transactions", "application/json", buf)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		defer resp.Body.Close()
+
+		var status struct {
+			Hash   string `json:"hash"`
+			Reason string `json:"reason"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&status)
 		if resp.StatusCode == 201 {
-			io.WriteString(w, string(utils.JsonStatus("success")))
+			// blockchain_serverが返したTransactionのハッシュを返す
+			m, _ := json.Marshal(struct {
+				Message string `json:"message"`
+				Hash    string `json:"hash"`
+			}{
+				Message: "success",
+				Hash:    status.Hash,
+			})
+			io.WriteString(w, string(m))
 			return
 		}
-		io.WriteString(w, string(utils.JsonStatus("fail")))
+
+		// blockchain_serverが拒否した理由をそのまま返す
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatusWithReason("fail", status.Reason)))
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		log.Println("ERROR: Invalid HTTP Method")
@@ -137,6 +283,9 @@ func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
 		// クエリパラメータを設定
 		q := bcsReq.URL.Query()
 		q.Add("blockchain_address", blockchainAddress)
+		if unconfirmed := req.URL.Query().Get("unconfirmed"); unconfirmed != "" {
+			q.Add("unconfirmed", unconfirmed)
+		}
 		bcsReq.URL.RawQuery = q.Encode()
 
 		// APIを叩く
@@ -161,11 +310,13 @@ func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
 			}
 
 			m, _ := json.Marshal(struct {
-				Message string  `json:"message"`
-				Amount  float32 `json:"amount"`
+				Message           string        `json:"message"`
+				Amount            utils.Amount  `json:"amount"`
+				UnconfirmedAmount *utils.Amount `json:"unconfirmed_amount,omitempty"`
 			}{
-				Message: "success",
-				Amount:  bar.Amount,
+				Message:           "success",
+				Amount:            bar.Amount,
+				UnconfirmedAmount: bar.UnconfirmedAmount,
 			})
 
 			io.WriteString(w, string(m[:]))
@@ -178,10 +329,334 @@ func (ws *WalletServer) WalletAmount(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// HDウォレットを作る、またはニーモニックから作り直すAPI
+// mnemonicを指定しなければ新しく作る。indexで指定した受け取り用のアドレスの鍵も返す
+func (ws *WalletServer) HDWallet(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+		var wr wallet.WalletRequest
+		if err := json.NewDecoder(req.Body).Decode(&wr); err != nil && err != io.EOF {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+
+		var hw *wallet.HDWallet
+		var err error
+		if wr.Mnemonic != nil {
+			hw, err = wallet.NewHDWalletFromMnemonic(*wr.Mnemonic, wr.Passphrase)
+		} else {
+			words := 12
+			if wr.Words != nil {
+				words = *wr.Words
+			}
+			hw, err = wallet.NewHDWalletWithMnemonic(words, wr.Passphrase)
+		}
+		var index uint32 = 0
+		if wr.Index != nil {
+			index = *wr.Index
+		}
+		var address *wallet.HDAddress
+		var key *wallet.Wallet
+		if err == nil {
+			address, err = hw.Address(index)
+		}
+		if err == nil {
+			key, err = hw.Wallet(index)
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
+			return
+		}
+
+		m, _ := json.Marshal(struct {
+			Mnemonic    string            `json:"mnemonic"`
+			Xpub        string            `json:"xpub"`
+			AccountPath string            `json:"account_path"`
+			Address     *wallet.HDAddress `json:"address"`
+			PrivateKey  string            `json:"private_key"`
+		}{
+			Mnemonic:    hw.Mnemonic(),
+			Xpub:        hw.Xpub(),
+			AccountPath: wallet.HD_ACCOUNT_PATH,
+			Address:     address,
+			PrivateKey:  key.PrivateKeyStr(),
+		})
+		io.WriteString(w, string(m))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// xpubのアカウントで、まだ返していない新しい受け取り用のアドレスを返すAPI
+// 返したアドレスの数はxpubごとに保存するので、wallet_serverを再起動しても同じアドレスは返さない
+func (ws *WalletServer) HDNextAddress(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+		var wr wallet.WalletRequest
+		if err := json.NewDecoder(req.Body).Decode(&wr); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if wr.Xpub == nil {
+			log.Printf("ERROR: %v", wallet.ErrMissingFields)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", wallet.ErrMissingFields.Error())))
+			return
+		}
+		hw, err := wallet.NewHDWalletFromExtendedKey(*wr.Xpub)
+		if err == nil && !hw.WatchOnly() {
+			err = errors.New("xpub is required, not xprv")
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
+			return
+		}
+
+		address, err := hw.NextAddress(ws.hdIndexes)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		m, _ := json.Marshal(struct {
+			Xpub    string            `json:"xpub"`
+			Address *wallet.HDAddress `json:"address"`
+		}{
+			Xpub:    hw.Xpub(),
+			Address: address,
+		})
+		io.WriteString(w, string(m))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// クエリパラメータのxpubから受け取り用のアドレスを導出して返すAPI。秘密鍵は扱わない
+// fromから始めてcount個返す。countの既定はHD_DEFAULT_ADDRESSES個と/wallet/hd/nextで返した数の多い方
+func (ws *WalletServer) HDAddresses(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		hw, from, count, ok := ws.parseHDQuery(w, req)
+		if !ok {
+			return
+		}
+		addresses, err := hw.Addresses(from, count)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
+			return
+		}
+		m, _ := json.Marshal(struct {
+			Xpub      string              `json:"xpub"`
+			Addresses []*wallet.HDAddress `json:"addresses"`
+		}{
+			Xpub:      hw.Xpub(),
+			Addresses: addresses,
+		})
+		io.WriteString(w, string(m))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// xpubから導出したアドレスの残高をblockchain_serverの/amountで調べて合計するAPI
+// クエリパラメータは/wallet/hd/addressesと同じ。unconfirmedも/wallet/amountと同じように使える
+func (ws *WalletServer) HDAmount(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		hw, from, count, ok := ws.parseHDQuery(w, req)
+		if !ok {
+			return
+		}
+		addresses, err := hw.Addresses(from, count)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
+			return
+		}
+
+		type addressAmount struct {
+			Index             uint32        `json:"index"`
+			BlockchainAddress string        `json:"blockchain_address"`
+			Amount            utils.Amount  `json:"amount"`
+			UnconfirmedAmount *utils.Amount `json:"unconfirmed_amount,omitempty"`
+		}
+		unconfirmed := req.URL.Query().Get("unconfirmed")
+		var total utils.Amount = 0
+		var totalUnconfirmed *utils.Amount
+		amounts := make([]*addressAmount, 0, len(addresses))
+		for _, a := range addresses {
+			bar, err := ws.fetchAmount(a.BlockchainAddress, unconfirmed)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				w.WriteHeader(http.StatusBadGateway)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+			total += bar.Amount
+			if bar.UnconfirmedAmount != nil {
+				if totalUnconfirmed == nil {
+					totalUnconfirmed = new(utils.Amount)
+				}
+				*totalUnconfirmed += *bar.UnconfirmedAmount
+			}
+			amounts = append(amounts, &addressAmount{
+				Index:             a.Index,
+				BlockchainAddress: a.BlockchainAddress,
+				Amount:            bar.Amount,
+				UnconfirmedAmount: bar.UnconfirmedAmount,
+			})
+		}
+
+		m, _ := json.Marshal(struct {
+			Message           string           `json:"message"`
+			Amount            utils.Amount     `json:"amount"`
+			UnconfirmedAmount *utils.Amount    `json:"unconfirmed_amount,omitempty"`
+			Addresses         []*addressAmount `json:"addresses"`
+		}{
+			Message:           "success",
+			Amount:            total,
+			UnconfirmedAmount: totalUnconfirmed,
+			Addresses:         amounts,
+		})
+		io.WriteString(w, string(m))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// xpub, from, countのクエリパラメータを読む。失敗した場合はレスポンスを書いてfalseを返す
+// countを省略した場合は、/wallet/hd/nextで返したアドレスまで含める(上限はHD_MAX_ADDRESSES個)
+func (ws *WalletServer) parseHDQuery(w http.ResponseWriter, req *http.Request) (*wallet.HDWallet, uint32, int, bool) {
+	q := req.URL.Query()
+	hw, err := wallet.NewHDWalletFromExtendedKey(q.Get("xpub"))
+	if err == nil && !hw.WatchOnly() {
+		// 秘密鍵を含むxprvはURLに載せない
+		err = errors.New("xpub is required, not xprv")
+	}
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, string(utils.JsonStatusWithReason("fail", err.Error())))
+		return nil, 0, 0, false
+	}
+
+	var from uint64 = 0
+	if s := q.Get("from"); s != "" {
+		if from, err = strconv.ParseUint(s, 10, 31); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", "invalid from")))
+			return nil, 0, 0, false
+		}
+	}
+	count := wallet.HD_DEFAULT_ADDRESSES
+	if issued := uint64(ws.hdIndexes.Issued(hw.Xpub())); issued > from+uint64(count) {
+		count = int(issued - from)
+		if count > wallet.HD_MAX_ADDRESSES {
+			count = wallet.HD_MAX_ADDRESSES
+		}
+	}
+	if s := q.Get("count"); s != "" {
+		if count, err = strconv.Atoi(s); err != nil || count <= 0 || count > wallet.HD_MAX_ADDRESSES ||
+			from+uint64(count) > wallet.HD_HARDENED {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatusWithReason("fail", "invalid count")))
+			return nil, 0, 0, false
+		}
+	}
+	return hw, uint32(from), count, true
+}
+
+// blockchain_serverの/amountで1つのアドレスの残高を取得する
+func (ws *WalletServer) fetchAmount(blockchainAddress string, unconfirmed string) (*block.AmountResponse, error) {
+	q := url.Values{}
+	q.Add("blockchain_address", blockchainAddress)
+	if unconfirmed != "" {
+		q.Add("unconfirmed", unconfirmed)
+	}
+	bcsResp, err := http.Get(ws.Gateway() + "/amount?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer bcsResp.Body.Close()
+	if bcsResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("amount of %s: status %d", blockchainAddress, bcsResp.StatusCode)
+	}
+	var bar block.AmountResponse
+	if err := json.NewDecoder(bcsResp.Body).Decode(&bar); err != nil {
+		return nil, err
+	}
+	return &bar, nil
+}
+
+// アドレスのTransactionの履歴をblockchain_serverから取得して返すAPI
+// パスとクエリパラメータはblockchain_serverの/address/{blockchain_address}/transactionsと同じ
+func (ws *WalletServer) AddressTransactions(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		endpoint := ws.Gateway() + req.URL.Path
+		if req.URL.RawQuery != "" {
+			endpoint += "?" + req.URL.RawQuery
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		bcsResp, err := http.Get(endpoint)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		defer bcsResp.Body.Close()
+
+		// blockchain_serverの結果をそのまま返す
+		w.WriteHeader(bcsResp.StatusCode)
+		io.Copy(w, bcsResp.Body)
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
 func (ws *WalletServer) Run() {
+	if err := os.MkdirAll(ws.DataDir(), 0700); err != nil {
+		log.Fatal(err)
+	}
+	hdIndexes, err := wallet.LoadHDIndexStore(filepath.Join(ws.DataDir(), wallet.HD_INDEX_FILE))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ws.hdIndexes = hdIndexes
+
 	http.HandleFunc("/", ws.Index)
 	http.HandleFunc("/wallet", ws.Wallet)
+	http.HandleFunc("/wallet/restore", ws.RestoreWallet)
+	http.HandleFunc("/wallet/hd", ws.HDWallet)
+	http.HandleFunc("/wallet/hd/next", ws.HDNextAddress)
+	http.HandleFunc("/wallet/hd/addresses", ws.HDAddresses)
+	http.HandleFunc("/wallet/hd/amount", ws.HDAmount)
 	http.HandleFunc("/wallet/amount", ws.WalletAmount)
+	http.HandleFunc("/address/", ws.AddressTransactions)
 	http.HandleFunc("/transaction", ws.CreateTransaction)
+	http.HandleFunc("/transaction/unsigned", ws.UnsignedTransaction)
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(ws.Port())), nil))
 }